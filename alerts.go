@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+	"github.com/fastly/compute-sdk-go/secretstore"
+	"github.com/valyala/fastjson"
+)
+
+const (
+	webhooksSecretName = "alert-webhooks"
+	alertLogKey        = "alert-log"
+	maxAlertLogCount   = 100
+)
+
+// alertInput is the snapshot the alert rules are evaluated against.
+type alertInput struct {
+	PowerAvg     float64
+	WindAvg      float64
+	Availability float64 // negative if unknown
+	Age          uint32  // seconds
+}
+
+// alertRule is a single condition that can fire a webhook.
+type alertRule struct {
+	Name    string
+	Firing  bool
+	Message string
+}
+
+// alertLogEntry records a rule firing or resolving, so digests can list the
+// alerts of a period.
+type alertLogEntry struct {
+	Time    time.Time
+	Rule    string
+	State   string
+	Message string
+}
+
+// webhook is a configured alert destination. Format is "slack" or "generic".
+type webhook struct {
+	Backend string
+	URL     string
+	Format  string
+}
+
+func alertRules(in alertInput) []alertRule {
+	cutIn := getConfigFloat("alert-cut-in-wind", 3.0)
+	minAvail := getConfigFloat("alert-min-availability", 90)
+	maxAge := getConfigFloat("alert-max-age-minutes", 30)
+
+	return []alertRule{
+		{
+			Name:    "stopped",
+			Firing:  in.PowerAvg <= 0 && in.WindAvg > cutIn,
+			Message: fmt.Sprintf("Turbine %s is producing no power with wind at %.1f m/s (cut-in %.1f m/s)", TID, in.WindAvg, cutIn),
+		},
+		{
+			Name:    "availability",
+			Firing:  in.Availability >= 0 && in.Availability < minAvail,
+			Message: fmt.Sprintf("Turbine %s availability is %.1f%% (threshold %.0f%%)", TID, in.Availability, minAvail),
+		},
+		{
+			Name:    "stale",
+			Firing:  float64(in.Age) > maxAge*60,
+			Message: fmt.Sprintf("Turbine %s data is %d minutes old (threshold %.0f minutes)", TID, in.Age/60, maxAge),
+		},
+	}
+}
+
+// refreshAlerts evaluates the rules against the live snapshot the live step
+// has just stored, so alerts fire on the refresh schedule whether or not
+// anyone is looking at the dashboard. If there is no snapshot it fetches one
+// and stores it for the refresh TTL like the live step.
+func refreshAlerts(ctx context.Context, store *kvstore.Store, ttl uint32) error {
+	entry, err := kvLookup(ctx, store, liveKey)
+	if errors.Is(err, kvstore.ErrKeyNotFound) {
+		if err := refreshLive(ctx, store, ttl); err != nil {
+			return err
+		}
+		entry, err = kvLookup(ctx, store, liveKey)
+	}
+	if err != nil {
+		return err
+	}
+	fetched, _ := strconv.ParseInt(string(entry.Meta()), 10, 64)
+	par, err := fastjson.Parse(entry.String())
+	if err != nil {
+		return err
+	}
+	availability := -1.0
+	if v := par.Get("data", "0", "availability"); v != nil {
+		availability = v.GetFloat64()
+	}
+	return evaluateAlerts(ctx, store, alertInput{
+		PowerAvg:     par.GetFloat64("data", "0", "powerAvg"),
+		WindAvg:      par.GetFloat64("data", "0", "windAvg"),
+		Availability: availability,
+		Age:          uint32(time.Since(time.Unix(fetched, 0)).Seconds()),
+	})
+}
+
+// evaluateAlerts checks every rule and notifies the webhooks on changes. A
+// firing rule is stored in KV so it is only re-sent after alert-repeat-hours,
+// and a resolved notice is sent once it clears. Changes are logged even with
+// no webhooks configured.
+func evaluateAlerts(ctx context.Context, store *kvstore.Store, in alertInput) error {
+	hooks, err := getWebhooks()
+	if err != nil {
+		return err
+	}
+	repeat := time.Duration(getConfigFloat("alert-repeat-hours", 6) * float64(time.Hour))
+	now := time.Now()
+
+	var errs []error
+	for _, rule := range alertRules(in) {
+		key := "alert-" + rule.Name
+		entry, lookupErr := kvLookup(ctx, store, key)
+		active := lookupErr == nil
+
+		switch {
+		case rule.Firing && active:
+			since, _ := strconv.ParseInt(entry.String(), 10, 64)
+			if now.Sub(time.Unix(since, 0)) < repeat {
+				continue
+			}
+			fallthrough
+		case rule.Firing:
+			errs = append(errs, notify(ctx, hooks, rule, "firing"))
+			errs = append(errs, store.Insert(key, bytes.NewReader([]byte(strconv.FormatInt(now.Unix(), 10)))))
+			errs = append(errs, logAlert(ctx, store, alertLogEntry{now, rule.Name, "firing", rule.Message}))
+		case active:
+			errs = append(errs, notify(ctx, hooks, rule, "resolved"))
+			errs = append(errs, store.Delete(key))
+			errs = append(errs, logAlert(ctx, store, alertLogEntry{now, rule.Name, "resolved", rule.Message}))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// logAlert prepends an entry to the alert log in KV, keeping the newest
+// maxAlertLogCount.
+func logAlert(ctx context.Context, store *kvstore.Store, e alertLogEntry) error {
+	log, err := getAlertLog(ctx, store)
+	if err != nil {
+		return err
+	}
+	log = append([]alertLogEntry{e}, log...)
+	if len(log) > maxAlertLogCount {
+		log = log[:maxAlertLogCount]
+	}
+
+	var a fastjson.Arena
+	arr := a.NewArray()
+	for i, e := range log {
+		o := a.NewObject()
+		o.Set("time", a.NewNumberInt(int(e.Time.Unix())))
+		o.Set("rule", a.NewString(e.Rule))
+		o.Set("state", a.NewString(e.State))
+		o.Set("message", a.NewString(e.Message))
+		arr.SetArrayItem(i, o)
+	}
+	return store.Insert(alertLogKey, bytes.NewReader(arr.MarshalTo(nil)))
+}
+
+// getAlertLog returns the logged alerts, newest first.
+func getAlertLog(ctx context.Context, store *kvstore.Store) ([]alertLogEntry, error) {
+	entry, err := kvLookup(ctx, store, alertLogKey)
+	if errors.Is(err, kvstore.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v, err := fastjson.Parse(entry.String())
+	if err != nil {
+		return nil, err
+	}
+	var log []alertLogEntry
+	for _, e := range v.GetArray() {
+		log = append(log, alertLogEntry{
+			Time:    time.Unix(e.GetInt64("time"), 0).UTC(),
+			Rule:    string(e.GetStringBytes("rule")),
+			State:   string(e.GetStringBytes("state")),
+			Message: string(e.GetStringBytes("message")),
+		})
+	}
+	return log, nil
+}
+
+func getWebhooks() ([]webhook, error) {
+	b, err := secretstore.Plaintext(secretStoreName, webhooksSecretName)
+	if errors.Is(err, secretstore.ErrSecretNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v, err := fastjson.ParseBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	var hooks []webhook
+	for _, h := range v.GetArray() {
+		hooks = append(hooks, webhook{
+			Backend: string(h.GetStringBytes("backend")),
+			URL:     string(h.GetStringBytes("url")),
+			Format:  string(h.GetStringBytes("format")),
+		})
+	}
+	return hooks, nil
+}
+
+func notify(ctx context.Context, hooks []webhook, rule alertRule, state string) error {
+	var errs []error
+	for _, h := range hooks {
+		req, err := fsthttp.NewRequest("POST", h.URL, bytes.NewReader(alertPayload(h.Format, rule, state)))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.CacheOptions = fsthttp.CacheOptions{Pass: true}
+		resp, err := send(ctx, req, h.Backend)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if resp.StatusCode > 299 {
+			errs = append(errs, fmt.Errorf("webhook %s: %s", h.Backend, fsthttp.StatusText(resp.StatusCode)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func alertPayload(format string, rule alertRule, state string) []byte {
+	var a fastjson.Arena
+	o := a.NewObject()
+	if format == "slack" {
+		text := ":rotating_light: " + rule.Message
+		if state == "resolved" {
+			text = ":white_check_mark: Resolved: " + rule.Message
+		}
+		o.Set("text", a.NewString(text))
+		return o.MarshalTo(nil)
+	}
+	o.Set("turbine", a.NewString(TID))
+	o.Set("rule", a.NewString(rule.Name))
+	o.Set("state", a.NewString(state))
+	o.Set("message", a.NewString(rule.Message))
+	o.Set("time", a.NewNumberInt(int(time.Now().Unix())))
+	return o.MarshalTo(nil)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fastjson"
+
+	"github.com/fastly/compute-sdk-go/erl"
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+)
+
+const (
+	apiKeyPrefix        = "wd_"
+	apiKeyKVPrefix      = "apikey-"
+	apiRateName         = "apikeys"
+	defaultAPIPerMinute = 60
+	apiPenalty          = time.Minute // the shortest a penalty box allows
+)
+
+// apiScopes maps the data API's paths to the scope a key needs for them.
+var apiScopes = map[string]string{
+	"/export/monthly": "export",
+	"/export/yearly":  "export",
+	"/export/daily":   "export",
+	"/api/v1/events":  "events",
+}
+
+// issuedKey is an API key as kept in KV under apikey-<sha256 of the key>, so
+// the key itself is never stored.
+type issuedKey struct {
+	ID        string // the hash
+	Name      string
+	Turbines  []string
+	Scopes    []string
+	PerMinute int
+}
+
+// Allows reports whether the key may be used for scope on this turbine. "*"
+// allows any.
+func (k issuedKey) Allows(scope string) bool {
+	return (slices.Contains(k.Turbines, TID) || slices.Contains(k.Turbines, "*")) &&
+		(slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, "*"))
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// presentedAPIKey is the key sent as X-API-Key or as a bearer token.
+func presentedAPIKey(r *fsthttp.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(key, apiKeyPrefix) {
+		return key
+	}
+	return ""
+}
+
+func lookupAPIKey(ctx context.Context, key string) (issuedKey, error) {
+	k := issuedKey{ID: hashAPIKey(key)}
+	store, err := kvstore.Open(kvStoreName)
+	if err != nil {
+		return k, err
+	}
+	entry, err := kvLookup(ctx, store, apiKeyKVPrefix+k.ID)
+	if err != nil {
+		return k, err
+	}
+	v, err := fastjson.Parse(entry.String())
+	if err != nil {
+		return k, err
+	}
+	k.Name = string(v.GetStringBytes("name"))
+	for _, t := range v.GetArray("turbines") {
+		k.Turbines = append(k.Turbines, string(t.GetStringBytes()))
+	}
+	for _, s := range v.GetArray("scopes") {
+		k.Scopes = append(k.Scopes, string(s.GetStringBytes()))
+	}
+	k.PerMinute = v.GetInt("perMinute")
+	if k.PerMinute <= 0 {
+		k.PerMinute = defaultAPIPerMinute
+	}
+	return k, nil
+}
+
+// requireAPIKey checks the API key of a data API request. It reports whether
+// a valid key was used, which stands in for the dashboard login, and whether
+// the request may go on; if not the response has been sent. Without a key
+// the request is anonymous, unless api-access-<turbine> is "keys".
+func requireAPIKey(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request, scope string) (bool, bool) {
+	presented := presentedAPIKey(r)
+	if presented == "" {
+		if getConfig("api-access-"+TID, "open") == "keys" {
+			apiError(w, fsthttp.StatusUnauthorized, "An API key is required")
+			return false, false
+		}
+		return false, true
+	}
+
+	l := logFor(ctx)
+	key, err := lookupAPIKey(ctx, presented)
+	if errors.Is(err, kvstore.ErrKeyNotFound) {
+		l.Warn("unknown API key", nil, "apiKey", key.ID[:12])
+		apiError(w, fsthttp.StatusUnauthorized, "Invalid API key")
+		return false, false
+	}
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return false, false
+	}
+	if !key.Allows(scope) {
+		l.Warn("API key out of scope", nil, "apiKey", key.ID[:12], "keyName", key.Name, "scope", scope)
+		apiError(w, fsthttp.StatusForbidden, "This API key can't be used for "+scope+" on this turbine")
+		return false, false
+	}
+
+	remaining, ok := rateLimit(ctx, key)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(key.PerMinute))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if !ok {
+		l.Warn("API key rate limited", nil, "apiKey", key.ID[:12], "keyName", key.Name)
+		w.Header().Set("Retry-After", strconv.Itoa(int(apiPenalty.Seconds())))
+		apiError(w, fsthttp.StatusTooManyRequests, "Rate limit exceeded")
+		return false, false
+	}
+	l.Info("API key", "apiKey", key.ID[:12], "keyName", key.Name, "scope", scope)
+	return true, true
+}
+
+// rateLimit counts a request against the key's per minute limit with the
+// POP's rate counter. Going over puts the key in the penalty box for a
+// minute. The counters are per POP and approximate, and if they fail the
+// request is let through rather than failing the API.
+func rateLimit(ctx context.Context, key issuedKey) (int, bool) {
+	rc := erl.OpenRateCounter(apiRateName)
+	pb := erl.OpenPenaltyBox(apiRateName)
+	if penalized, err := pb.Has(key.ID); err != nil {
+		logFor(ctx).Warn("checking penalty box", err)
+	} else if penalized {
+		return 0, false
+	}
+	if err := rc.Increment(key.ID, 1); err != nil {
+		logFor(ctx).Warn("counting API request", err)
+		return key.PerMinute, true
+	}
+	count, err := rc.LookupCount(key.ID, erl.CounterDuration60s)
+	if err != nil {
+		logFor(ctx).Warn("counting API request", err)
+		return key.PerMinute, true
+	}
+	if int(count) > key.PerMinute {
+		if err := pb.Add(key.ID, apiPenalty); err != nil {
+			logFor(ctx).Warn("penalizing API key", err)
+		}
+		return 0, false
+	}
+	return key.PerMinute - int(count), true
+}
+
+// apiError sends a JSON error to API clients.
+func apiError(w fsthttp.ResponseWriter, status int, msg string) {
+	var a fastjson.Arena
+	o := a.NewObject()
+	o.Set("error", a.NewString(msg))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(status)
+	w.Write(o.MarshalTo(nil))
+}
+
+// apiKeys issues and revokes API keys. POST makes a new key from name, one or
+// more scope and turbine parameters and optionally per-minute, and shows it
+// only in this response. DELETE with id=<id> revokes one.
+func apiKeys(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	if !bearerAuthorized(r, refreshSecretName) {
+		w.WriteHeader(fsthttp.StatusUnauthorized)
+		fmt.Fprintf(w, "Unauthorized\n")
+		return
+	}
+	if r.Method != "POST" && r.Method != "DELETE" {
+		w.Header().Set("Allow", "POST, DELETE")
+		w.WriteHeader(fsthttp.StatusMethodNotAllowed)
+		fmt.Fprintf(w, "Use POST to issue a key and DELETE to revoke one\n")
+		return
+	}
+	store, err := kvstore.Open(kvStoreName)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+
+	q := r.URL.Query()
+	if r.Method == "DELETE" {
+		id := q.Get("id")
+		if id == "" {
+			apiError(w, fsthttp.StatusBadRequest, "id is required")
+			return
+		}
+		err := store.Delete(apiKeyKVPrefix + id)
+		if errors.Is(err, kvstore.ErrKeyNotFound) {
+			apiError(w, fsthttp.StatusNotFound, "No such API key")
+			return
+		}
+		if err != nil {
+			httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+			return
+		}
+		logFor(ctx).Info("API key revoked", "apiKey", id)
+		w.WriteHeader(fsthttp.StatusNoContent)
+		return
+	}
+
+	name := q.Get("name")
+	perMinute := defaultAPIPerMinute
+	if s := q.Get("per-minute"); s != "" {
+		if perMinute, err = strconv.Atoi(s); err != nil || perMinute <= 0 {
+			apiError(w, fsthttp.StatusBadRequest, "Bad per-minute")
+			return
+		}
+	}
+	if name == "" || len(q["scope"]) == 0 || len(q["turbine"]) == 0 {
+		apiError(w, fsthttp.StatusBadRequest, "name, scope and turbine are required")
+		return
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	key := apiKeyPrefix + hex.EncodeToString(b)
+	id := hashAPIKey(key)
+
+	var a fastjson.Arena
+	o := a.NewObject()
+	o.Set("name", a.NewString(name))
+	turbines := a.NewArray()
+	for i, t := range q["turbine"] {
+		turbines.SetArrayItem(i, a.NewString(t))
+	}
+	o.Set("turbines", turbines)
+	scopes := a.NewArray()
+	for i, s := range q["scope"] {
+		scopes.SetArrayItem(i, a.NewString(s))
+	}
+	o.Set("scopes", scopes)
+	o.Set("perMinute", a.NewNumberInt(perMinute))
+	o.Set("created", a.NewNumberInt(int(time.Now().Unix())))
+	if err := store.Insert(apiKeyKVPrefix+id, bytes.NewReader(o.MarshalTo(nil))); err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	logFor(ctx).Info("API key issued", "apiKey", id[:12], "keyName", name)
+
+	o.Set("id", a.NewString(id))
+	o.Set("key", a.NewString(key))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(fsthttp.StatusCreated)
+	w.Write(o.MarshalTo(nil))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fastjson"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/secretstore"
+)
+
+const (
+	sessionCookie        = "windash_session"
+	sessionKeySecretName = "session-key"
+	defaultSessionHours  = 12
+	dashboardUsersPrefix = "dashboard-users-"
+	dashboardRealm       = `Basic realm="Wind Turbine Dashboard", charset="UTF-8"`
+)
+
+// turbinePrivate reports whether the turbine's dashboard is private, set with
+// access-<turbine> in the config store. Dashboards are public by default.
+func turbinePrivate() bool {
+	return getConfig("access-"+TID, "public") == "private"
+}
+
+// publicPath reports whether a path stays open on a private dashboard: the
+// icons, and the internal endpoints which have their own tokens.
+func publicPath(path string) bool {
+	return path == "/favicon.ico" || path == "/favicon.svg" || strings.HasPrefix(path, "/internal/")
+}
+
+// requireAccess lets a request through to a private dashboard if it carries a
+// valid session cookie or the credentials of one of the turbine's users, and
+// otherwise asks for them. A successful login starts a session, so scripts
+// and the browser's follow-up requests don't check the password each time.
+// The returned writer keeps private responses out of shared caches. keyed
+// requests have already been let in with an API key, their responses are
+// private too as they carry the key's rate limit.
+func requireAccess(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request, keyed bool) (fsthttp.ResponseWriter, bool) {
+	if keyed {
+		return &privateWriter{ResponseWriter: w}, true
+	}
+	if !turbinePrivate() || publicPath(r.URL.Path) {
+		return w, true
+	}
+	w = &privateWriter{ResponseWriter: w}
+
+	key, err := secretstore.Plaintext(secretStoreName, sessionKeySecretName)
+	if err != nil && !errors.Is(err, secretstore.ErrSecretNotFound) {
+		logFor(ctx).Warn("session key unavailable", err)
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil && len(key) > 0 {
+		if _, ok := verifySession(key, c.Value); ok {
+			return w, true
+		}
+	}
+
+	user, ok := basicAuthorized(ctx, r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", dashboardRealm)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.WriteHeader(fsthttp.StatusUnauthorized)
+		fmt.Fprintf(w, "Unauthorized\n")
+		return w, false
+	}
+	logFor(ctx).Info("login", "user", user)
+	if len(key) > 0 {
+		maxAge := int(getConfigFloat("session-hours", defaultSessionHours) * 3600)
+		fsthttp.SetCookie(w.Header(), &fsthttp.Cookie{
+			Name:     sessionCookie,
+			Value:    signSession(key, user, time.Now().Add(time.Duration(maxAge)*time.Second)),
+			Path:     "/",
+			MaxAge:   maxAge,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: fsthttp.SameSiteLaxMode,
+		})
+	}
+	return w, true
+}
+
+// basicAuthorized checks HTTP Basic credentials against the turbine's users,
+// a JSON object of user names and passwords in the secret
+// dashboard-users-<turbine>. A missing secret denies everyone.
+func basicAuthorized(ctx context.Context, r *fsthttp.Request) (string, bool) {
+	encoded, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Basic ")
+	if !ok {
+		return "", false
+	}
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	user, password, ok := strings.Cut(string(b), ":")
+	if !ok || user == "" {
+		return "", false
+	}
+
+	secret, err := secretstore.Plaintext(secretStoreName, dashboardUsersPrefix+TID)
+	if err != nil {
+		if !errors.Is(err, secretstore.ErrSecretNotFound) {
+			logFor(ctx).Error("dashboard users unavailable", err)
+		}
+		return "", false
+	}
+	users, err := fastjson.ParseBytes(secret)
+	if err != nil {
+		logFor(ctx).Error("parsing dashboard users", err)
+		return "", false
+	}
+	want := users.GetStringBytes(user)
+	if len(want) == 0 || subtle.ConstantTimeCompare([]byte(password), want) != 1 {
+		logFor(ctx).Warn("login failed", nil, "user", user)
+		return "", false
+	}
+	return user, true
+}
+
+// signSession makes a session cookie value: the user, the expiry and an HMAC
+// over both and the turbine, so a session for one turbine is no good for
+// another.
+func signSession(key []byte, user string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(user)) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + sessionMAC(key, payload)
+}
+
+func sessionMAC(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(TID + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySession checks a session cookie value and returns its user.
+func verifySession(key []byte, value string) (string, bool) {
+	i := strings.LastIndex(value, ".")
+	if i < 0 {
+		return "", false
+	}
+	payload, sig := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(sessionMAC(key, payload))) {
+		return "", false
+	}
+	encodedUser, expires, _ := strings.Cut(payload, ".")
+	ts, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().After(time.Unix(ts, 0)) {
+		return "", false
+	}
+	user, err := base64.RawURLEncoding.DecodeString(encodedUser)
+	if err != nil {
+		return "", false
+	}
+	return string(user), true
+}
+
+// privateWriter turns public caching into private caching, so a private
+// dashboard is never kept at the edge where anyone could be served it.
+type privateWriter struct {
+	fsthttp.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *privateWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		if cc := h.Get("Cache-Control"); strings.Contains(cc, "public") {
+			h.Set("Cache-Control", strings.Replace(cc, "public", "private", 1))
+		}
+		h.Del("Surrogate-Control")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *privateWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(fsthttp.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/valyala/fastjson"
+)
+
+// Chart canvas and plot area in SVG user units.
+const (
+	chartWidth  = 800.0
+	chartHeight = 320.0
+	chartLeft   = 56.0
+	chartRight  = chartWidth - 16
+	chartTop    = 48.0
+	chartBottom = chartHeight - 40
+)
+
+// chartSeries is one line or set of bars. Colors holds a per point colour for
+// bar charts and falls back to Color.
+type chartSeries struct {
+	Label  string
+	Values []float64
+	Color  string
+	Colors []string
+	Dashed bool
+	Fill   bool
+}
+
+// chartSVG renders a line or bar chart with the same colours as the Chart.js
+// charts on the dashboard. unit labels the y axis, whose ticks are formatted
+// for l.
+func chartSVG(l locale, title, unit string, bar bool, labels []string, series []chartSeries) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %.0f %.0f" width="%.0f" height="%.0f" font-family="sans-serif">`, chartWidth, chartHeight, chartWidth, chartHeight)
+	b.WriteString(`<rect width="100%" height="100%" fill="#ffffff"/>`)
+	fmt.Fprintf(&b, `<text x="%.0f" y="22" font-size="16" font-weight="bold" fill="#1f2937">%s</text>`, chartLeft, xmlEscape(title))
+
+	// Legend
+	x := chartLeft
+	for _, s := range series {
+		fmt.Fprintf(&b, `<rect x="%.0f" y="30" width="12" height="8" fill="%s"/>`, x, s.Color)
+		fmt.Fprintf(&b, `<text x="%.0f" y="38" font-size="11" fill="#4b5563">%s</text>`, x+16, xmlEscape(s.Label))
+		x += 24 + float64(len(s.Label))*6
+	}
+
+	// Y axis from zero, or the minimum if negative, to a rounded maximum
+	lo, hi := 0.0, 0.0
+	for _, s := range series {
+		for _, v := range s.Values {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	step := niceStep((hi - lo) / 5)
+	lo, hi = math.Floor(lo/step)*step, math.Ceil(hi/step)*step
+	if hi == lo {
+		hi = lo + step
+	}
+	y := func(v float64) float64 {
+		return chartBottom - (v-lo)/(hi-lo)*(chartBottom-chartTop)
+	}
+	for v := lo; v <= hi+step/2; v += step {
+		fmt.Fprintf(&b, `<line x1="%.0f" y1="%.1f" x2="%.0f" y2="%.1f" stroke="#e5e7eb"/>`, chartLeft, y(v), chartRight, y(v))
+		fmt.Fprintf(&b, `<text x="%.0f" y="%.1f" font-size="10" fill="#6b7280" text-anchor="end">%s</text>`, chartLeft-6, y(v)+3, formatTick(l, v))
+	}
+	fmt.Fprintf(&b, `<text x="14" y="%.0f" font-size="11" fill="#6b7280" transform="rotate(-90 14 %.0f)" text-anchor="middle">%s</text>`, (chartTop+chartBottom)/2, (chartTop+chartBottom)/2, xmlEscape(unit))
+
+	// X axis labels, thinned out so they don't overlap
+	n := len(labels)
+	slot := (chartRight - chartLeft) / float64(max(n, 1))
+	every := int(math.Ceil(float64(n) * 60 / (chartRight - chartLeft)))
+	for i, l := range labels {
+		if i%max(every, 1) != 0 {
+			continue
+		}
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.0f" font-size="10" fill="#6b7280" text-anchor="middle">%s</text>`, chartLeft+slot*(float64(i)+0.5), chartBottom+16, xmlEscape(l))
+	}
+
+	for si, s := range series {
+		if bar {
+			width := slot * 0.8 / float64(len(series))
+			for i, v := range s.Values {
+				color := s.Color
+				if i < len(s.Colors) {
+					color = s.Colors[i]
+				}
+				bx := chartLeft + slot*float64(i) + slot*0.1 + width*float64(si)
+				top, bottom := y(math.Max(v, 0)), y(math.Min(v, 0))
+				fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %s</title></rect>`, bx, top, width, bottom-top, color, xmlEscape(labels[i]), formatTick(l, v))
+			}
+			continue
+		}
+		var points []string
+		for i, v := range s.Values {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", chartLeft+slot*(float64(i)+0.5), y(v)))
+		}
+		if len(points) == 0 {
+			continue
+		}
+		if s.Fill {
+			fmt.Fprintf(&b, `<polygon points="%.1f,%.1f %s %.1f,%.1f" fill="%s" fill-opacity="0.2"/>`,
+				chartLeft+slot*0.5, y(lo), strings.Join(points, " "), chartLeft+slot*(float64(len(points))-0.5), y(lo), s.Color)
+		}
+		dash := ""
+		if s.Dashed {
+			dash = ` stroke-dasharray="5 5"`
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"%s/>`, strings.Join(points, " "), s.Color, dash)
+	}
+
+	b.WriteString(`</svg>`)
+	return []byte(b.String())
+}
+
+// niceStep rounds a raw axis step up to 1, 2 or 5 times a power of ten.
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 1
+	}
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+func formatTick(l locale, v float64) string {
+	if v == math.Trunc(v) {
+		return l.Num(v, 0)
+	}
+	return l.Num(v, 1)
+}
+
+// renderChart builds the named chart from the same data as the dashboard, in
+// the units of u.
+func renderChart(ctx context.Context, name string, u unitPrefs, l locale) ([]byte, error) {
+	switch name {
+	case "wind", "availability", "daily":
+		days, err := getLast30Days(ctx)
+		if err != nil {
+			return nil, err
+		}
+		labels := make([]string, len(days))
+		windAvg := make([]float64, len(days))
+		windMax := make([]float64, len(days))
+		avail := make([]float64, len(days))
+		lowWind := make([]float64, len(days))
+		energy := make([]float64, len(days))
+		dailyUnit := u.EnergyUnit(maxDailyYield(days))
+		for i, d := range days {
+			lowWind[i] = d.LowWindTime / 86400 * 100
+			d = convertDay(d, dailyUnit, u.Wind)
+			labels[i] = l.Date(d.Date, "2 Jan")
+			windAvg[i] = d.WindAvg
+			windMax[i] = d.WindMax
+			avail[i] = d.Avail
+			energy[i] = d.EnergyYield
+		}
+		switch name {
+		case "wind":
+			return chartSVG(l, l.T("Wind Speed"), u.Wind.Name, false, labels, []chartSeries{
+				{Label: l.T("Average Wind Speed (%s)", u.Wind.Name), Values: windAvg, Color: "#10b981", Fill: true},
+				{Label: l.T("Max Wind Speed (%s)", u.Wind.Name), Values: windMax, Color: "#f59e0b", Dashed: true},
+			}), nil
+		case "availability":
+			return chartSVG(l, l.T("Availability & Low Wind"), "%", false, labels, []chartSeries{
+				{Label: l.T("Availability %"), Values: avail, Color: "#10b981", Fill: true},
+				{Label: l.T("Low Wind Time %"), Values: lowWind, Color: "#f59e0b", Dashed: true},
+			}), nil
+		default:
+			return chartSVG(l, l.T("Daily Energy Production"), dailyUnit.Name, true, labels, []chartSeries{
+				{Label: l.T("Energy Production (%s)", dailyUnit.Name), Values: energy, Color: "#2563eb"},
+			}), nil
+		}
+
+	case "monthly":
+		data, err := getLast12Months(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v, err := fastjson.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		var labels, colors []string
+		var values []float64
+		unit := u.EnergyUnit(maxArray(v, "energyYield") * 1e3)
+		for i, m := range v.GetArray("months") {
+			labels = append(labels, l.MonthLabel(string(m.GetStringBytes())))
+			values = append(values, unit.FromKWh(v.GetArray("energyYield")[i].GetFloat64()*1e3))
+			if v.GetArray("isCurrentMonth")[i].GetBool() {
+				colors = append(colors, "#059669")
+			} else {
+				colors = append(colors, "#10b981")
+			}
+		}
+		return chartSVG(l, l.T("Monthly Energy Production (Last 12 Months)"), unit.Name, true, labels, []chartSeries{
+			{Label: l.T("Monthly Energy Production (%s)", unit.Name), Values: values, Color: "#10b981", Colors: colors},
+		}), nil
+
+	case "yearly":
+		data, err := getYearsSince2020(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v, err := fastjson.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		var labels []string
+		var values []float64
+		unit := u.EnergyUnit(maxArray(v, "energyYield") * 1e6)
+		for i, y := range v.GetArray("years") {
+			labels = append(labels, string(y.GetStringBytes()))
+			values = append(values, unit.FromKWh(v.GetArray("energyYield")[i].GetFloat64()*1e6))
+		}
+		return chartSVG(l, l.T("Yearly Energy Production (Since 2022)"), unit.Name, true, labels, []chartSeries{
+			{Label: l.T("Yearly Energy Production (%s)", unit.Name), Values: values, Color: "#8b5cf6"},
+		}), nil
+	}
+	return nil, nil
+}
+
+func chart(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/charts/"), ".svg")
+	svg, err := renderChart(ctx, name, getUnitPrefs(r), negotiateLocale(r))
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error rendering chart", err)
+		return
+	}
+	if svg == nil {
+		w.WriteHeader(fsthttp.StatusNotFound)
+		fmt.Fprintf(w, "The page you requested could not be found\n")
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	w.Header().Add("Vary", "Accept-Language")
+	w.Write(svg)
+}
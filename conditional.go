@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/valyala/fastjson"
+)
+
+// newETag starts the hash of a response's data with what decides how it is
+// represented, like the format, units and language. Write the underlying data
+// to it and pass it to etagValue.
+func newETag(repr ...string) hash.Hash64 {
+	h := fnv.New64a()
+	for _, s := range repr {
+		io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+	return h
+}
+
+// etagValue is a weak ETag, the bytes can differ for the same data, for
+// example with CSV number formatting.
+func etagValue(h hash.Hash64) string {
+	return fmt.Sprintf(`W/"%016x"`, h.Sum64())
+}
+
+// exportRepr describes the representation of an export for its ETag.
+func exportRepr(r *fsthttp.Request, format string) []string {
+	u := getUnitPrefs(r)
+	return []string{format, u.EnergyPref(), u.Wind.Name, negotiateLocale(r).Lang}
+}
+
+// dataModified is when data up to to last changed: the end of its last day
+// once that is over, otherwise the newest slot the API has published.
+func dataModified(to time.Time) time.Time {
+	end := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	if slot := latestMeanSlot(); slot.Before(end) {
+		return slot
+	}
+	return end
+}
+
+// aggregateModified is when monthly or yearly aggregates last changed: the end
+// of the newest period with energy, labelled in layout under labelsKey, once
+// that period is over. While the current period has data it keeps changing,
+// so it returns zero and only the ETag validates.
+func aggregateModified(v *fastjson.Value, labelsKey, layout string) time.Time {
+	labels, yields := v.GetArray(labelsKey), v.GetArray("energyYield")
+	for i := min(len(labels), len(yields)) - 1; i >= 0; i-- {
+		if yields[i].GetFloat64() <= 0 {
+			continue
+		}
+		start, err := time.Parse(layout, string(labels[i].GetStringBytes()))
+		if err != nil {
+			return time.Time{}
+		}
+		end := start.AddDate(0, 1, 0)
+		if layout == "2006" {
+			end = start.AddDate(1, 0, 0)
+		}
+		if end.After(time.Now()) {
+			return time.Time{}
+		}
+		return end
+	}
+	return time.Time{}
+}
+
+// notModified sets the validators of a response and, if the request's
+// conditions show the client already has it, sends a 304 with the headers
+// set so far. If-None-Match takes precedence over If-Modified-Since. A zero
+// modified time sends no Last-Modified.
+func notModified(w fsthttp.ResponseWriter, r *fsthttp.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(fsthttp.TimeFormat))
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+
+	match := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		match = etagMatch(inm, etag)
+	} else if ims, err := time.Parse(fsthttp.TimeFormat, r.Header.Get("If-Modified-Since")); err == nil && !modified.IsZero() {
+		match = !modified.Truncate(time.Second).After(ims)
+	}
+	if match {
+		w.WriteHeader(fsthttp.StatusNotModified)
+	}
+	return match
+}
+
+// etagMatch compares etag against an If-None-Match list, weakly as RFC 9110
+// asks for GET.
+func etagMatch(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/fsttest"
+	"github.com/valyala/fastjson"
+)
+
+func TestETagMatch(t *testing.T) {
+	const etag = `W/"0123456789abcdef"`
+	tests := []struct {
+		list string
+		want bool
+	}{
+		{`W/"0123456789abcdef"`, true},
+		{`"0123456789abcdef"`, true},
+		{`*`, true},
+		{`"aaaa", W/"0123456789abcdef"`, true},
+		{`"aaaa",W/"0123456789abcdef" , "bbbb"`, true},
+		{`"aaaa", *`, true},
+		{`"aaaa", "bbbb"`, false},
+		{`W/"0123456789abcdeF"`, false},
+		{`0123456789abcdef`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatch(tt.list, etag); got != tt.want {
+			t.Errorf("etagMatch(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+	if !etagMatch(`W/"0123456789abcdef"`, `"0123456789abcdef"`) {
+		t.Error("a weak tag doesn't match the same strong ETag")
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	const etag = `W/"0123456789abcdef"`
+	modified := time.Date(2025, 4, 17, 10, 30, 15, 500, time.UTC)
+	httpDate := func(t time.Time) string { return t.Format(fsthttp.TimeFormat) }
+
+	tests := []struct {
+		name     string
+		method   string
+		header   map[string]string
+		modified time.Time
+		want     bool
+	}{
+		{"no conditions", "GET", nil, modified, false},
+		{"matching ETag", "GET", map[string]string{"If-None-Match": etag}, modified, true},
+		{"matching ETag on HEAD", "HEAD", map[string]string{"If-None-Match": etag}, modified, true},
+		{"strong form of the ETag", "GET", map[string]string{"If-None-Match": `"0123456789abcdef"`}, modified, true},
+		{"ETag in a list", "GET", map[string]string{"If-None-Match": `"other", ` + etag}, modified, true},
+		{"any ETag", "GET", map[string]string{"If-None-Match": "*"}, modified, true},
+		{"other ETag", "GET", map[string]string{"If-None-Match": `"other"`}, modified, false},
+		{"not modified since", "GET", map[string]string{"If-Modified-Since": httpDate(modified)}, modified, true},
+		{"modified since", "GET", map[string]string{"If-Modified-Since": httpDate(modified.Add(-time.Second))}, modified, false},
+		{"bad date", "GET", map[string]string{"If-Modified-Since": "yesterday"}, modified, false},
+		{"date without Last-Modified", "GET", map[string]string{"If-Modified-Since": httpDate(modified)}, time.Time{}, false},
+		{"other ETag wins over date", "GET", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": httpDate(modified)}, modified, false},
+		{"matching ETag wins over date", "GET", map[string]string{"If-None-Match": etag, "If-Modified-Since": httpDate(modified.Add(-time.Hour))}, modified, true},
+		{"POST", "POST", map[string]string{"If-None-Match": etag}, modified, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := fsthttp.NewRequest(tt.method, "https://example.com/export/monthly", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := fsttest.NewRecorder()
+			if got := notModified(w, r, etag, tt.modified); got != tt.want {
+				t.Errorf("notModified = %v, want %v", got, tt.want)
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			wantLastModified := ""
+			if !tt.modified.IsZero() {
+				wantLastModified = "Thu, 17 Apr 2025 10:30:15 GMT"
+			}
+			if got := w.Header().Get("Last-Modified"); got != wantLastModified {
+				t.Errorf("Last-Modified = %q, want %q", got, wantLastModified)
+			}
+			wantCode := fsthttp.StatusOK
+			if tt.want {
+				wantCode = fsthttp.StatusNotModified
+			}
+			if w.Code != wantCode {
+				t.Errorf("status = %d, want %d", w.Code, wantCode)
+			}
+		})
+	}
+}
+
+func TestETagRepresentation(t *testing.T) {
+	etag := func(repr ...string) string {
+		h := newETag(repr...)
+		h.Write([]byte("data"))
+		return etagValue(h)
+	}
+	if etag("csv", "auto", "m/s", "en") != etag("csv", "auto", "m/s", "en") {
+		t.Error("the same representation has different ETags")
+	}
+	if etag("csv", "auto", "m/s", "en") == etag("csv", "auto", "m/s", "de") {
+		t.Error("languages share an ETag")
+	}
+	if etag("ab", "c") == etag("a", "bc") {
+		t.Error("representation parts run together")
+	}
+}
+
+func TestAggregateModified(t *testing.T) {
+	thisYear := time.Now().UTC().Year()
+	tests := []struct {
+		name string
+		json string
+		want time.Time
+	}{
+		{"newest completed month", `{"months":["Mar 2024","Apr 2024","May 2024"],"energyYield":[80,75,0]}`, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"completed year", `{"years":["2022","2023"],"energyYield":[5.1,4.8]}`, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"current year has data", fmt.Sprintf(`{"years":["%d","%d"],"energyYield":[5.1,1.2]}`, thisYear-1, thisYear), time.Time{}},
+		{"no data", `{"months":["Mar 2024"],"energyYield":[0]}`, time.Time{}},
+	}
+	for _, tt := range tests {
+		v := fastjson.MustParse(tt.json)
+		labelsKey, layout := "months", "Jan 2006"
+		if v.Exists("years") {
+			labelsKey, layout = "years", "2006"
+		}
+		if got := aggregateModified(v, labelsKey, layout); !got.Equal(tt.want) {
+			t.Errorf("%s: aggregateModified = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/fastly/compute-sdk-go/configstore"
+)
+
+const configStoreName = "windash-config"
+
+// getConfig returns the value for key from the config store, or def if the
+// store or key is missing.
+func getConfig(key, def string) string {
+	store, err := configstore.Open(configStoreName)
+	if err != nil {
+		return def
+	}
+	v, err := store.Get(key)
+	if err != nil || v == "" {
+		return def
+	}
+	return v
+}
+
+// getConfigFloat is getConfig for numeric settings.
+func getConfigFloat(key string, def float64) float64 {
+	f, err := strconv.ParseFloat(getConfig(key, ""), 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
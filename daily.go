@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+	"github.com/valyala/fastjson"
+)
+
+// The turbine's data starts in 2021, and a daily export covers at most about
+// two years so one request can't walk the whole archive month by month.
+var firstDataDay = time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+const maxDailyRangeDays = 2 * 366
+
+// dailyRow is one day of performance data.
+type dailyRow struct {
+	Date        time.Time
+	EnergyYield float64 // kWh
+	WindAvg     float64 // m/s
+	WindMax     float64 // m/s
+	Avail       float64 // %
+	LowWindTime float64 // s
+}
+
+// getMonthDays returns the daily performance for a month, keeping completed
+// months in KV.
+func getMonthDays(ctx context.Context, year, month int) (string, error) {
+	store, err := kvstore.Open(kvStoreName)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	monthStart := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	currentMonthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	isCompletedPastMonth := monthStart.Before(currentMonthStart)
+
+	keyStr := fmt.Sprintf("daily-%04d%02d", year, month)
+	if isCompletedPastMonth {
+		if entry, err := kvLookup(ctx, store, keyStr); err == nil {
+			return entry.String(), nil
+		}
+	}
+
+	data, err := fetchMonthDays(ctx, year, month)
+	if err != nil {
+		return "", err
+	}
+	if isCompletedPastMonth {
+		store.Insert(keyStr, bytes.NewReader([]byte(data)))
+	}
+	return data, nil
+}
+
+// eachDay calls fn for every day between from and to inclusive, fetching one
+// month at a time so long ranges are never held in memory at once.
+func eachDay(ctx context.Context, from, to time.Time, fn func(dailyRow) error) error {
+	for m := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(to); m = m.AddDate(0, 1, 0) {
+		data, err := getMonthDays(ctx, m.Year(), int(m.Month()))
+		if err != nil {
+			return err
+		}
+		v, err := fastjson.Parse(data)
+		if err != nil {
+			return err
+		}
+		for _, day := range v.GetArray("data") {
+			d, err := rowDate(day)
+			if err != nil {
+				logFor(ctx).Warn("skipping undated day", err, "month", m.Format("2006-01"))
+				continue
+			}
+			if d.Before(from) || d.After(to) {
+				continue
+			}
+			err = fn(dailyRow{
+				Date:        d,
+				EnergyYield: day.GetFloat64("energyYield"),
+				WindAvg:     day.GetFloat64("windAvg"),
+				WindMax:     day.GetFloat64("windMax"),
+				Avail:       day.GetFloat64("availability"),
+				LowWindTime: day.GetFloat64("lowWindTime"),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rowDate is the day a row of the API's daily data is for. Only the date part
+// of its date member is used, whether or not a time follows.
+func rowDate(day *fastjson.Value) (time.Time, error) {
+	s := string(day.GetStringBytes("date"))
+	if len(s) > len(time.DateOnly) {
+		s = s[:len(time.DateOnly)]
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// getLast30Days returns the 30 days up to yesterday.
+func getLast30Days(ctx context.Context) ([]dailyRow, error) {
+	data, err := last30(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, err := fastjson.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	var days []dailyRow
+	for _, day := range v.GetArray("data") {
+		d, err := rowDate(day)
+		if err != nil {
+			logFor(ctx).Warn("skipping undated day", err)
+			continue
+		}
+		days = append(days, dailyRow{
+			Date:        d,
+			EnergyYield: day.GetFloat64("energyYield"),
+			WindAvg:     day.GetFloat64("windAvg"),
+			WindMax:     day.GetFloat64("windMax"),
+			Avail:       day.GetFloat64("availability"),
+			LowWindTime: day.GetFloat64("lowWindTime"),
+		})
+	}
+	return days, nil
+}
+
+// rollupDays summarises the days between from and to: mean wind, peak wind,
+// mean availability and total low wind time.
+func rollupDays(ctx context.Context, from, to time.Time) (dailyRow, error) {
+	r := dailyRow{Date: from}
+	days := 0
+	err := eachDay(ctx, from, to, func(d dailyRow) error {
+		days++
+		r.EnergyYield += d.EnergyYield
+		r.WindAvg += d.WindAvg
+		r.WindMax = max(r.WindMax, d.WindMax)
+		r.Avail += d.Avail
+		r.LowWindTime += d.LowWindTime
+		return nil
+	})
+	if days > 0 {
+		r.WindAvg /= float64(days)
+		r.Avail /= float64(days)
+	}
+	return r, err
+}
+
+// parseDateRange reads the from and to query parameters (YYYY-MM-DD). The
+// range is clamped to the days with data, from firstDataDay up to today, and
+// may be at most maxDailyRangeDays long.
+func parseDateRange(r *fsthttp.Request) (time.Time, time.Time, error) {
+	q := r.URL.Query()
+	from, err := time.Parse(time.DateOnly, q.Get("from"))
+	if err != nil {
+		return from, from, fmt.Errorf("bad from date %q", q.Get("from"))
+	}
+	to, err := time.Parse(time.DateOnly, q.Get("to"))
+	if err != nil {
+		return from, to, fmt.Errorf("bad to date %q", q.Get("to"))
+	}
+	now := time.Now().UTC()
+	if today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC); to.After(today) {
+		to = today
+	}
+	if from.Before(firstDataDay) {
+		from = firstDataDay
+	}
+	if from.After(to) {
+		return from, to, fmt.Errorf("from is after to")
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > maxDailyRangeDays {
+		return from, to, fmt.Errorf("range of %d days is longer than %d", days, maxDailyRangeDays)
+	}
+	return from, to, nil
+}
+
+func exportDaily(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	format, err := exportFormat(w, r)
+	if err != nil {
+		w.WriteHeader(fsthttp.StatusNotAcceptable)
+		fmt.Fprintf(w, "%v\n", err)
+		return
+	}
+
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		w.WriteHeader(fsthttp.StatusBadRequest)
+		fmt.Fprintf(w, "%v\n", err)
+		return
+	}
+	filename := fmt.Sprintf("daily_production_%s_%s", from.Format("20060102"), to.Format("20060102"))
+
+	// Rows are streamed, so automatic scaling goes by the most a day can
+	// produce. Parquet keeps the shared schema's kWh and m/s.
+	u := getUnitPrefs(r)
+	energy := u.EnergyUnit(powerNominal * 24)
+	if format != "parquet" {
+		setUnitsHeader(w, energy, u.Wind)
+	}
+	setSurrogateKeys(w, dayKeys(from, to))
+
+	// The ETag is built without reading the data, so the rows can be
+	// streamed. Completed months are kept under their KV keys and don't
+	// change, a range into the current month changes with each new slot.
+	etag := newETag(exportRepr(r, format)...)
+	io.WriteString(etag, from.Format(time.DateOnly)+to.Format(time.DateOnly))
+	now := time.Now().UTC()
+	currentMonthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for m := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(to); m = m.AddDate(0, 1, 0) {
+		if m.Before(currentMonthStart) {
+			fmt.Fprintf(etag, "daily-%04d%02d", m.Year(), int(m.Month()))
+		} else {
+			io.WriteString(etag, latestMeanSlot().UTC().Format(time.RFC3339))
+		}
+	}
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	if notModified(w, r, etagValue(etag), dataModified(to)) {
+		return
+	}
+
+	// Rows are written as they are read, so errors after the first row can
+	// only be logged. A JSON export that fails is left unterminated rather
+	// than passed off as complete.
+	switch format {
+	case "csv":
+		l := negotiateLocale(r)
+		csvHeaders(w, l, filename+".csv")
+
+		writeCSVRow(w, l, l.T("Date"), l.T("Energy (%s)", energy.Name), l.T("Wind Avg (%s)", u.Wind.Name), l.T("Wind Max (%s)", u.Wind.Name), l.T("Availability (%)"), l.T("Low Wind Time (s)"))
+		err = eachDay(ctx, from, to, func(d dailyRow) error {
+			d = convertDay(d, energy, u.Wind)
+			return writeCSVRow(w, l, d.Date.Format(time.DateOnly), l.Num(d.EnergyYield, energy.Decimals+2), l.Num(d.WindAvg, 2), l.Num(d.WindMax, 2), l.Num(d.Avail, 2), l.Num(d.LowWindTime, 0))
+		})
+	case "xlsx":
+		w.Header().Set("Content-Type", xlsxContentType)
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename+".xlsx")
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		err = writeDailyXLSX(ctx, w, from, to, energy, u.Wind)
+	case "parquet":
+		w.Header().Set("Content-Type", parquetContentType)
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename+".parquet")
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		err = writeDailyParquet(ctx, w, from, to)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename+".json")
+		w.Header().Set("Cache-Control", "public, max-age=600")
+
+		fmt.Fprintf(w, `{"from":"%s","to":"%s","units":{"energy":"%s","wind":"%s"},"data":[`, from.Format(time.DateOnly), to.Format(time.DateOnly), energy.Name, u.Wind.Name)
+		first := true
+		err = eachDay(ctx, from, to, func(d dailyRow) error {
+			if !first {
+				fmt.Fprint(w, ",")
+			}
+			first = false
+			d = convertDay(d, energy, u.Wind)
+			_, err := fmt.Fprintf(w, `{"date":"%s","energyYield":%f,"windAvg":%f,"windMax":%f,"availability":%f,"lowWindTime":%f}`, d.Date.Format(time.DateOnly), d.EnergyYield, d.WindAvg, d.WindMax, d.Avail, d.LowWindTime)
+			return err
+		})
+		if err == nil {
+			fmt.Fprint(w, "]}")
+		}
+	}
+	if err != nil {
+		logFor(ctx).Error("writing export", err, "format", format)
+	}
+}
+
+// writeDailyXLSX streams the daily rows into a workbook. The summary sheet
+// comes last since it needs the totals.
+func writeDailyXLSX(ctx context.Context, w io.Writer, from, to time.Time, eu energyUnit, wu windUnit) error {
+	x := newXLSXWriter(w)
+	energyHeader := "Energy (" + eu.Name + ")"
+	windHeader := "Wind Avg (" + wu.Name + ")"
+	err := x.StartSheet("Daily", "Date", energyHeader, windHeader, "Wind Max ("+wu.Name+")", "Availability (%)", "Low Wind Time (s)")
+	if err != nil {
+		return err
+	}
+	days, energy, wind, avail := 0, 0.0, 0.0, 0.0
+	err = eachDay(ctx, from, to, func(d dailyRow) error {
+		d = convertDay(d, eu, wu)
+		days++
+		energy += d.EnergyYield
+		wind += d.WindAvg
+		avail += d.Avail
+		return x.Row(d.Date.Format(time.DateOnly), d.EnergyYield, d.WindAvg, d.WindMax, d.Avail, d.LowWindTime)
+	})
+	if err != nil {
+		return err
+	}
+	if days > 0 {
+		wind /= float64(days)
+		avail /= float64(days)
+	}
+
+	if err := x.StartSheet("Summary", "Item", "Value"); err != nil {
+		return err
+	}
+	summary := [][]any{
+		{"Turbine", TID},
+		{"From", from.Format(time.DateOnly)},
+		{"To", to.Format(time.DateOnly)},
+		{"Days", float64(days)},
+		{"Total " + energyHeader, energy},
+		{"Mean " + windHeader, wind},
+		{"Mean Availability (%)", avail},
+		{"Generated", time.Now().UTC().Format(time.RFC3339)},
+	}
+	for _, row := range summary {
+		if err := x.Row(row...); err != nil {
+			return err
+		}
+	}
+	return x.Close()
+}
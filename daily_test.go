@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+)
+
+func TestParseDateRange(t *testing.T) {
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query    string
+		from, to time.Time
+		wantErr  bool
+	}{
+		{query: "from=2023-01-01&to=2023-01-31", from: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{query: "from=0001-01-01&to=2021-03-01", from: firstDataDay, to: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{query: "from=" + today.AddDate(0, 0, -7).Format(time.DateOnly) + "&to=9999-12-31", from: today.AddDate(0, 0, -7), to: today},
+		{query: "from=2022-01-01&to=2023-12-31", from: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{query: "from=2022-01-01&to=2024-01-03", wantErr: true},
+		{query: "from=0001-01-01&to=2024-01-01", wantErr: true},
+		{query: "from=2023-02-01&to=2023-01-01", wantErr: true},
+		{query: "from=2023-1-1&to=2023-01-31", wantErr: true},
+		{query: "to=2023-01-31", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, err := fsthttp.NewRequest("GET", "https://example.com/export/daily?"+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		from, to, err := parseDateRange(r)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseDateRange(%q) = %s, %s, want error", tt.query, from.Format(time.DateOnly), to.Format(time.DateOnly))
+			}
+			continue
+		}
+		if err != nil || !from.Equal(tt.from) || !to.Equal(tt.to) {
+			t.Errorf("parseDateRange(%q) = %s, %s, %v, want %s, %s", tt.query, from.Format(time.DateOnly), to.Format(time.DateOnly), err, tt.from.Format(time.DateOnly), tt.to.Format(time.DateOnly))
+		}
+	}
+}
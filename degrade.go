@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"time"
+
+	_ "embed"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/valyala/fastjson"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+)
+
+//go:embed error.html.tmpl
+var errorTemplate string
+
+// lastGoodInterval is how often a section's last good copy is rewritten, to
+// keep KV writes down on busy pages.
+const lastGoodInterval = 10 * time.Minute
+
+// lastGood keeps the last successfully fetched data of a dashboard section
+// in KV. When fetching failed (err is set), it returns that copy instead
+// along with when it was saved, or the original error if there is none. The
+// time is zero when data is fresh.
+func lastGood(ctx context.Context, section, data string, err error) (string, time.Time, error) {
+	store, openErr := kvstore.Open(kvStoreName)
+	if openErr != nil {
+		return data, time.Time{}, err
+	}
+	key := "lastgood-" + section
+	entry, lookupErr := kvLookup(ctx, store, key)
+	var saved time.Time
+	if lookupErr == nil {
+		ts, _ := strconv.ParseInt(string(entry.Meta()), 10, 64)
+		saved = time.Unix(ts, 0)
+	}
+
+	if err == nil {
+		if lookupErr != nil || time.Since(saved) > lastGoodInterval {
+			insertErr := store.InsertWithConfig(key, bytes.NewReader([]byte(data)), &kvstore.InsertConfig{
+				Metadata: []byte(strconv.FormatInt(time.Now().Unix(), 10)),
+			})
+			if insertErr != nil {
+				logFor(ctx).Warn("saving last good copy", insertErr, "section", section)
+			}
+		}
+		return data, time.Time{}, nil
+	}
+
+	if lookupErr != nil {
+		logFor(ctx).Error("section unavailable", err, "section", section)
+		return data, time.Time{}, err
+	}
+	logFor(ctx).Warn("serving last good copy", err, "section", section, "savedAt", saved.UTC().Format(time.RFC3339))
+	return entry.String(), saved, nil
+}
+
+// dashboardState records which sections of the dashboard could be filled and
+// how old the oldest fallback copy is.
+type dashboardState struct {
+	Missing    []string
+	StaleSince time.Time
+}
+
+// track notes the outcome of lastGood for a section and reports whether
+// there is data to show.
+func (s *dashboardState) track(section string, stale time.Time, err error) bool {
+	if err != nil {
+		s.Missing = append(s.Missing, section)
+		return false
+	}
+	if !stale.IsZero() && (s.StaleSince.IsZero() || stale.Before(s.StaleSince)) {
+		s.StaleSince = stale
+	}
+	return true
+}
+
+// Degraded reports whether any section is missing or stale.
+func (s *dashboardState) Degraded() bool {
+	return len(s.Missing) > 0 || !s.StaleSince.IsZero()
+}
+
+// daysJSON and parseDays carry the last 30 days through lastGood.
+func daysJSON(days []dailyRow) string {
+	var a fastjson.Arena
+	arr := a.NewArray()
+	for i, d := range days {
+		o := a.NewObject()
+		o.Set("date", a.NewString(d.Date.Format(time.DateOnly)))
+		o.Set("energyYield", a.NewNumberFloat64(d.EnergyYield))
+		o.Set("windAvg", a.NewNumberFloat64(d.WindAvg))
+		o.Set("windMax", a.NewNumberFloat64(d.WindMax))
+		o.Set("availability", a.NewNumberFloat64(d.Avail))
+		o.Set("lowWindTime", a.NewNumberFloat64(d.LowWindTime))
+		arr.SetArrayItem(i, o)
+	}
+	return string(arr.MarshalTo(nil))
+}
+
+func parseDays(s string) ([]dailyRow, error) {
+	v, err := fastjson.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	var days []dailyRow
+	for _, d := range v.GetArray() {
+		date, err := time.Parse(time.DateOnly, string(d.GetStringBytes("date")))
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, dailyRow{
+			Date:        date,
+			EnergyYield: d.GetFloat64("energyYield"),
+			WindAvg:     d.GetFloat64("windAvg"),
+			WindMax:     d.GetFloat64("windMax"),
+			Avail:       d.GetFloat64("availability"),
+			LowWindTime: d.GetFloat64("lowWindTime"),
+		})
+	}
+	return days, nil
+}
+
+// errorPage renders the HTML error page for when there is nothing at all to
+// show, with the request ID for reporting the problem.
+func errorPage(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request, status int, err error) {
+	t, tmplErr := pongo2.FromString(errorTemplate)
+	if tmplErr != nil {
+		httpError(ctx, w, status, fsthttp.StatusText(status), err)
+		return
+	}
+	l := negotiateLocale(r)
+	reqLog := logFor(ctx)
+	reqLog.Error("error page", err, "status", status)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("Vary", "Accept-Language")
+	if status == fsthttp.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "60")
+	}
+	w.WriteHeader(status)
+	err = t.ExecuteWriter(pongo2.Context{
+		"status":    status,
+		"requestID": reqLog.ID,
+		"retry":     status >= 500,
+	}.Update(l.templateContext()), w)
+	if err != nil {
+		reqLog.Error("rendering error page", err)
+	}
+}
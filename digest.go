@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "embed"
+
+	"github.com/flosch/pongo2/v6"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+)
+
+//go:embed digest.html.tmpl
+var digestHTMLTemplate string
+
+//go:embed digest.txt.tmpl
+var digestTextTemplate string
+
+// digestSentTTL keeps the record of a sent digest longer than the longest
+// period, so a repeated scheduler run can't send it twice.
+const digestSentTTL = 40 * 24 * 60 * 60 // seconds
+
+// digest summarises a week or a month for the email digest.
+type digest struct {
+	Period         string // weekly or monthly
+	Label          string
+	From           time.Time
+	To             time.Time // inclusive
+	EnergyYield    float64   // MWh
+	CapacityFactor float64   // %
+	Availability   float64   // %
+	BestDay        dailyRow
+	TopWindDay     dailyRow
+	Days           []dailyRow
+	Alerts         []alertLogEntry
+	Events         []turbineEvent
+}
+
+// digestRange returns the last completed ISO week or calendar month.
+func digestRange(period string, now time.Time) (time.Time, time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case "weekly":
+		daysSinceMonday := (int(today.Weekday()) + 6) % 7
+		from := today.AddDate(0, 0, -daysSinceMonday-7)
+		return from, from.AddDate(0, 0, 6), nil
+	case "monthly":
+		from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+		return from, from.AddDate(0, 1, -1), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("unknown period %q", period)
+}
+
+func getDigest(ctx context.Context, period string) (digest, error) {
+	from, to, err := digestRange(period, time.Now())
+	if err != nil {
+		return digest{}, err
+	}
+	d := digest{Period: period, From: from, To: to, Label: "Weekly digest"}
+	if period == "monthly" {
+		d.Label = "Monthly digest " + from.Format("January 2006")
+	}
+
+	err = eachDay(ctx, from, to, func(day dailyRow) error {
+		d.Days = append(d.Days, day)
+		d.EnergyYield += day.EnergyYield / 1e3
+		d.Availability += day.Avail
+		if day.EnergyYield > d.BestDay.EnergyYield {
+			d.BestDay = day
+		}
+		if day.WindAvg > d.TopWindDay.WindAvg {
+			d.TopWindDay = day
+		}
+		return nil
+	})
+	if err != nil {
+		return d, err
+	}
+	if len(d.Days) > 0 {
+		d.Availability /= float64(len(d.Days))
+	}
+	theoreticalMaxMWh := (powerNominal / 1000.0) * to.AddDate(0, 0, 1).Sub(from).Hours()
+	d.CapacityFactor = (d.EnergyYield / theoreticalMaxMWh) * 100
+
+	end := to.AddDate(0, 0, 1)
+	store, err := kvstore.Open(kvStoreName)
+	if err != nil {
+		return d, err
+	}
+	alerts, err := getAlertLog(ctx, store)
+	if err != nil {
+		return d, err
+	}
+	for _, a := range alerts {
+		if !a.Time.Before(from) && a.Time.Before(end) {
+			d.Alerts = append(d.Alerts, a)
+		}
+	}
+	events, err := getEvents(ctx)
+	if err != nil {
+		return d, err
+	}
+	for _, e := range events {
+		if !e.Time.Before(from) && e.Time.Before(end) {
+			d.Events = append(d.Events, e)
+		}
+	}
+	return d, nil
+}
+
+// renderDigest renders the digest email for the dashboard at baseURL.
+func renderDigest(d digest, baseURL string) (mailMessage, error) {
+	subject := fmt.Sprintf("Turbine %s: %.1f MWh %s", TID, d.EnergyYield, d.From.Format("2 Jan"))
+	if d.Period == "monthly" {
+		subject = fmt.Sprintf("Turbine %s: %.1f MWh in %s", TID, d.EnergyYield, d.From.Format("January 2006"))
+	} else {
+		subject += " to " + d.To.Format("2 Jan")
+	}
+	reportURL := ""
+	if d.Period == "monthly" {
+		reportURL = fmt.Sprintf("%s/reports/monthly?year=%d&month=%d", baseURL, d.From.Year(), int(d.From.Month()))
+	}
+	ctx := pongo2.Context{
+		"turbine":   TID,
+		"subject":   subject,
+		"digest":    d,
+		"baseURL":   baseURL,
+		"reportURL": reportURL,
+	}
+
+	m := mailMessage{
+		From:    getConfig("mail-from", defaultMailFrom),
+		To:      mailRecipients(),
+		Subject: subject,
+	}
+	for _, part := range []struct {
+		tmpl string
+		dst  *string
+	}{
+		{digestHTMLTemplate, &m.HTML},
+		{digestTextTemplate, &m.Text},
+	} {
+		t, err := pongo2.FromString(part.tmpl)
+		if err != nil {
+			return m, err
+		}
+		s, err := t.Execute(ctx)
+		if err != nil {
+			return m, err
+		}
+		*part.dst = s
+	}
+	return m, nil
+}
+
+// digestMail is hit by the scheduler with period=weekly or monthly to send
+// the digest for the last completed period. preview=html or text renders it
+// without sending, for checking the templates.
+func digestMail(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	if !bearerAuthorized(r, refreshSecretName) {
+		w.WriteHeader(fsthttp.StatusUnauthorized)
+		fmt.Fprintf(w, "Unauthorized\n")
+		return
+	}
+	q := r.URL.Query()
+	d, err := getDigest(ctx, q.Get("period"))
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error building digest", err)
+		return
+	}
+	m, err := renderDigest(d, "https://"+r.Host)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error rendering digest", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-store")
+	switch q.Get("preview") {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, m.HTML)
+		return
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, m.Text)
+		return
+	}
+
+	store, err := kvstore.Open(kvStoreName)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	sentKey := fmt.Sprintf("digest-%s-%s", d.Period, d.From.Format(time.DateOnly))
+	err = store.InsertWithConfig(sentKey, bytes.NewReader([]byte(time.Now().Format(time.RFC3339))), &kvstore.InsertConfig{
+		Mode:   kvstore.InsertModeAdd,
+		TTLSec: digestSentTTL,
+	})
+	if errors.Is(err, kvstore.ErrPreconditionFailed) {
+		w.WriteHeader(fsthttp.StatusConflict)
+		fmt.Fprintf(w, "Digest already sent\n")
+		return
+	}
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	if err := newMailer().Send(ctx, m); err != nil {
+		// Let the next run try again
+		store.Delete(sentKey)
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error sending digest", err)
+		return
+	}
+	fmt.Fprintf(w, "Sent %q to %d recipients\n", m.Subject, len(m.To))
+}
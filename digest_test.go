@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+func TestDigestCapture(t *testing.T) {
+	day := func(d int, energy, wind float64) dailyRow {
+		return dailyRow{Date: time.Date(2025, 4, d, 0, 0, 0, 0, time.UTC), EnergyYield: energy, WindAvg: wind, WindMax: wind * 2, Avail: 99}
+	}
+	weekly := digest{
+		Period:         "weekly",
+		Label:          "Weekly digest",
+		From:           time.Date(2025, 4, 7, 0, 0, 0, 0, time.UTC),
+		To:             time.Date(2025, 4, 13, 0, 0, 0, 0, time.UTC),
+		EnergyYield:    12.34,
+		CapacityFactor: 14.7,
+		Availability:   99,
+		BestDay:        day(9, 4200, 7.5),
+		TopWindDay:     day(10, 3900, 8.25),
+		Days:           []dailyRow{day(9, 4200, 7.5), day(10, 3900, 8.25)},
+		Alerts: []alertLogEntry{
+			{time.Date(2025, 4, 8, 14, 5, 0, 0, time.UTC), "stopped", "firing", "Turbine 277 is producing no power"},
+		},
+	}
+	monthly := digest{
+		Period:      "monthly",
+		Label:       "Monthly digest April 2025",
+		From:        time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2025, 4, 30, 0, 0, 0, 0, time.UTC),
+		EnergyYield: 80.05,
+	}
+
+	tests := []struct {
+		name    string
+		digest  digest
+		subject string
+		html    []string
+		text    []string
+		absent  []string
+	}{
+		{
+			name:    "weekly with an alert",
+			digest:  weekly,
+			subject: "Turbine 277: 12.3 MWh 7 Apr to 13 Apr",
+			html: []string{
+				"<title>Turbine 277: 12.3 MWh 7 Apr to 13 Apr</title>",
+				"Weekly digest &middot; 7 Apr to 13 Apr 2025",
+				"12.3 MWh",
+				"<strong>Wed 9 Apr</strong> with 4200 kWh",
+				"<strong>Thu 10 Apr</strong> averaging 8.2 m/s (max 16.5 m/s)",
+				`#dc2626">firing</span> 8 Apr 14:05 &middot; Turbine 277 is producing no power`,
+				`<img src="https://example.com/charts/daily.svg"`,
+			},
+			text: []string{
+				"Weekly digest: 7 Apr to 13 Apr 2025",
+				"Energy:          12.3 MWh",
+				"Best day:        Wed 9 Apr, 4200 kWh",
+				"Alerts\n- 8 Apr 14:05 firing: Turbine 277 is producing no power\n",
+				"Daily chart: https://example.com/charts/daily.svg",
+			},
+			absent: []string{"No alerts.", "No production data", "Monthly report", "monthly.svg"},
+		},
+		{
+			name:    "monthly without data",
+			digest:  monthly,
+			subject: "Turbine 277: 80.0 MWh in April 2025",
+			html: []string{
+				"No production data for this period.",
+				"No alerts.",
+				`href="https://example.com/reports/monthly?year=2025&amp;month=4"`,
+				`<img src="https://example.com/charts/monthly.svg"`,
+			},
+			text: []string{
+				"Monthly digest April 2025: 1 Apr to 30 Apr 2025",
+				"Alerts\nNo alerts.\n",
+				"Monthly report: https://example.com/reports/monthly?year=2025&month=4",
+				"Monthly chart: https://example.com/charts/monthly.svg",
+			},
+			absent: []string{"Best day", "Status changes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := renderDigest(tt.digest, "https://example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			outbox, err := captureOutbox("", m, time.Unix(1744700000, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := fastjson.ParseBytes(outbox)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(v.GetArray()); n != 1 {
+				t.Fatalf("outbox has %d messages, want 1", n)
+			}
+			captured := v.Get("0")
+			if got := string(captured.GetStringBytes("subject")); got != tt.subject {
+				t.Errorf("subject = %q, want %q", got, tt.subject)
+			}
+			if got := captured.GetInt("time"); got != 1744700000 {
+				t.Errorf("time = %d, want 1744700000", got)
+			}
+			html, text := string(captured.GetStringBytes("html")), string(captured.GetStringBytes("text"))
+			for _, want := range tt.html {
+				if !strings.Contains(html, want) {
+					t.Errorf("HTML body is missing %q", want)
+				}
+			}
+			for _, want := range tt.text {
+				if !strings.Contains(text, want) {
+					t.Errorf("text body is missing %q:\n%s", want, text)
+				}
+			}
+			for _, unwanted := range tt.absent {
+				if strings.Contains(html, unwanted) || strings.Contains(text, unwanted) {
+					t.Errorf("body has %q", unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestCaptureOutboxKeepsNewest(t *testing.T) {
+	prev := ""
+	for i := range maxOutboxCount + 5 {
+		outbox, err := captureOutbox(prev, mailMessage{Subject: "digest " + string(rune('a'+i))}, time.Unix(int64(i), 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev = string(outbox)
+	}
+	v := fastjson.MustParse(prev)
+	if n := len(v.GetArray()); n != maxOutboxCount {
+		t.Fatalf("outbox has %d messages, want %d", n, maxOutboxCount)
+	}
+	if got, want := string(v.GetStringBytes("0", "subject")), "digest "+string(rune('a'+maxOutboxCount+4)); got != want {
+		t.Errorf("newest subject = %q, want %q", got, want)
+	}
+	if got := v.GetInt("0", "time"); got != maxOutboxCount+4 {
+		t.Errorf("newest time = %d, want %d", got, maxOutboxCount+4)
+	}
+}
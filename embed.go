@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	_ "embed"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/valyala/fastjson"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+)
+
+//go:embed embed.html.tmpl
+var embedTemplate string
+
+// embedHeaders allows the widget and badge to be used from other sites. Which
+// sites may frame the widget is set by embed-frame-ancestors in the config
+// store.
+func embedHeaders(w fsthttp.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors "+getConfig("embed-frame-ancestors", "*"))
+	w.Header().Set("Cache-Control", "public, max-age=300")
+}
+
+// embedTheme returns the theme query parameter, falling back to the
+// embed-theme config setting.
+func embedTheme(r *fsthttp.Request) string {
+	theme := r.URL.Query().Get("theme")
+	if theme != "light" && theme != "dark" {
+		theme = getConfig("embed-theme", "light")
+	}
+	return theme
+}
+
+// embedLive serves a small self-contained HTML page with the current turbine
+// state, meant to be included with an iframe.
+func embedLive(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	latestPerf, age, err := getLatestPerf(ctx)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	par, err := fastjson.Parse(latestPerf)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	t, err := pongo2.FromString(embedTemplate)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	status, err := getCurrentStatus(ctx)
+	if err != nil {
+		logFor(ctx).Warn("status unavailable", err)
+	}
+
+	u := getUnitPrefs(r)
+	energyYield := par.GetFloat64("data", "0", "energyYield")
+	energyUnit := u.EnergyUnit(energyYield)
+
+	embedHeaders(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = t.ExecuteWriter(pongo2.Context{
+		"turbine":     TID,
+		"theme":       embedTheme(r),
+		"status":      status,
+		"powerAvg":    par.GetFloat64("data", "0", "powerAvg"),
+		"windAvg":     u.WindSpeed(par.GetFloat64("data", "0", "windAvg")),
+		"windUnit":    u.Wind,
+		"energyYield": energyUnit.FromKWh(energyYield),
+		"energyUnit":  energyUnit,
+		"lastUpdate":  time.Now().Add(-time.Second * time.Duration(age)).UTC().Format("15:04 MST"),
+	}, w)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+}
+
+// badge serves a shields.io style SVG badge with the current power and state.
+func badge(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	latestPerf, _, err := getLatestPerf(ctx)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	par, err := fastjson.Parse(latestPerf)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	status, err := getCurrentStatus(ctx)
+	if err != nil {
+		logFor(ctx).Warn("status unavailable", err)
+	}
+
+	label := r.URL.Query().Get("label")
+	if label == "" {
+		label = "turbine " + TID
+	}
+	message := fmt.Sprintf("%.0f kW", par.GetFloat64("data", "0", "powerAvg"))
+	color := "#4c1"
+	switch status.State {
+	case stateError:
+		message += " | error"
+		color = "#e05d44"
+	case stateStopped, stateMaintenance:
+		message += " | " + status.State
+		color = "#dfb317"
+	}
+	labelColor := "#555"
+	if embedTheme(r) == "dark" {
+		labelColor = "#1f2937"
+	}
+
+	embedHeaders(w)
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(badgeSVG(label, message, labelColor, color))
+}
+
+// badgeSVG renders a flat badge. Text widths are estimated since there is no
+// font metrics to measure with.
+func badgeSVG(label, message, labelColor, color string) []byte {
+	lw := 10 + len(label)*7
+	mw := 10 + len(message)*7
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[3]s: %[4]s">`+
+		`<title>%[3]s: %[4]s</title>`+
+		`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`+
+		`<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>`+
+		`<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="%[5]s"/><rect x="%[2]d" width="%[7]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[3]s</text><text x="%[8]d" y="14">%[3]s</text>`+
+		`<text x="%[9]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[9]d" y="14">%[4]s</text>`+
+		`</g></svg>`,
+		lw+mw, lw, xmlEscape(label), xmlEscape(message), labelColor, color, mw, lw/2, lw+mw/2))
+}
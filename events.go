@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+	"github.com/valyala/fastjson"
+)
+
+const (
+	eventsKey     = "events"
+	statusKey     = "status-current"
+	maxEventCount = 200
+)
+
+// Turbine states recorded in the event log.
+const (
+	stateRunning     = "running"
+	stateStopped     = "stopped"
+	stateError       = "error"
+	stateMaintenance = "maintenance"
+)
+
+// turbineEvent is a single state transition of the turbine.
+type turbineEvent struct {
+	Time      time.Time
+	State     string
+	ErrorCode int
+	Text      string
+}
+
+// getStatus fetches the current turbine status from Vensys.
+func getStatus(ctx context.Context) (turbineEvent, error) {
+	var e turbineEvent
+	resp, err := vensysGet(ctx, "Status", nil, liveRoute())
+	if err != nil {
+		return e, err
+	}
+	if resp.StatusCode > 299 {
+		return e, errors.New(fsthttp.StatusText(resp.StatusCode))
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return e, err
+	}
+	v, err := fastjson.ParseBytes(b)
+	if err != nil {
+		return e, err
+	}
+	d := v.Get("data", "0")
+	if d == nil {
+		return e, errors.New("no status data")
+	}
+
+	e.Time = time.Now().UTC()
+	e.ErrorCode = d.GetInt("errorCode")
+	e.Text = string(d.GetStringBytes("statusText"))
+	e.State = classifyStatus(d.GetInt("statusCode"), e.ErrorCode, e.Text)
+	return e, nil
+}
+
+// classifyStatus maps the raw Vensys status onto one of the turbine states.
+func classifyStatus(code, errorCode int, text string) string {
+	if errorCode != 0 {
+		return stateError
+	}
+	t := strings.ToLower(text)
+	if strings.Contains(t, "maintenance") || strings.Contains(t, "service") {
+		return stateMaintenance
+	}
+	if code == 0 || strings.Contains(t, "stop") {
+		return stateStopped
+	}
+	return stateRunning
+}
+
+// refreshStatus fetches the current status and appends an event to the log in
+// KV if the state or error code changed since the last poll. Only the refresh
+// job writes the log, so overlapping page views can't lose events.
+func refreshStatus(ctx context.Context, store *kvstore.Store, _ uint32) error {
+	current, err := getStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if entry, err := kvLookup(ctx, store, statusKey); err == nil {
+		last, err := parseEvent(entry.String())
+		if err == nil && last.State == current.State && last.ErrorCode == current.ErrorCode {
+			return nil
+		}
+	}
+
+	events, err := readEvents(ctx, store)
+	if err != nil {
+		return err
+	}
+	events = append([]turbineEvent{current}, events...)
+	if len(events) > maxEventCount {
+		events = events[:maxEventCount]
+	}
+	if err := store.Insert(eventsKey, strings.NewReader(eventsJSON(events))); err != nil {
+		return err
+	}
+	var a fastjson.Arena
+	return store.Insert(statusKey, bytes.NewReader(eventValue(&a, current).MarshalTo(nil)))
+}
+
+// getCurrentStatus returns the status the refresh job last recorded, timed
+// from when it changed.
+func getCurrentStatus(ctx context.Context) (turbineEvent, error) {
+	store, err := kvstore.Open(kvStoreName)
+	if err != nil {
+		return turbineEvent{}, err
+	}
+	entry, err := kvLookup(ctx, store, statusKey)
+	if err != nil {
+		return turbineEvent{}, err
+	}
+	return parseEvent(entry.String())
+}
+
+// getEvents returns the recorded state transitions, newest first.
+func getEvents(ctx context.Context) ([]turbineEvent, error) {
+	store, err := kvstore.Open(kvStoreName)
+	if err != nil {
+		return nil, err
+	}
+	return readEvents(ctx, store)
+}
+
+func readEvents(ctx context.Context, store *kvstore.Store) ([]turbineEvent, error) {
+	entry, err := kvLookup(ctx, store, eventsKey)
+	if errors.Is(err, kvstore.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v, err := fastjson.Parse(entry.String())
+	if err != nil {
+		return nil, err
+	}
+	var events []turbineEvent
+	for _, ev := range v.GetArray("events") {
+		events = append(events, eventFromValue(ev))
+	}
+	return events, nil
+}
+
+func parseEvent(s string) (turbineEvent, error) {
+	v, err := fastjson.Parse(s)
+	if err != nil {
+		return turbineEvent{}, err
+	}
+	return eventFromValue(v), nil
+}
+
+func eventFromValue(v *fastjson.Value) turbineEvent {
+	return turbineEvent{
+		Time:      time.Unix(v.GetInt64("time"), 0).UTC(),
+		State:     string(v.GetStringBytes("state")),
+		ErrorCode: v.GetInt("errorCode"),
+		Text:      string(v.GetStringBytes("text")),
+	}
+}
+
+func eventValue(a *fastjson.Arena, e turbineEvent) *fastjson.Value {
+	o := a.NewObject()
+	o.Set("time", a.NewNumberInt(int(e.Time.Unix())))
+	o.Set("state", a.NewString(e.State))
+	o.Set("errorCode", a.NewNumberInt(e.ErrorCode))
+	o.Set("text", a.NewString(e.Text))
+	return o
+}
+
+func eventsJSON(events []turbineEvent) string {
+	var a fastjson.Arena
+	arr := a.NewArray()
+	for i, e := range events {
+		arr.SetArrayItem(i, eventValue(&a, e))
+	}
+	o := a.NewObject()
+	o.Set("events", arr)
+	return string(o.MarshalTo(nil))
+}
+
+func eventsAPI(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	events, err := getEvents(ctx)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error fetching events", err)
+		return
+	}
+	data := eventsJSON(events)
+	etag := newETag()
+	io.WriteString(etag, data)
+	var modified time.Time
+	for _, e := range events {
+		if e.Time.After(modified) {
+			modified = e.Time
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	if notModified(w, r, etagValue(etag), modified) {
+		return
+	}
+	fmt.Fprint(w, data)
+}
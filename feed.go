@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+)
+
+// feedMonths is how many completed months the feed publishes.
+const feedMonths = 12
+
+// writeFeedEntry writes one Atom entry summarising a completed month, in the
+// units of u and the language of l. The entry links to the report in the
+// same units and language, prefs being the query that carries them over.
+func writeFeedEntry(w io.Writer, baseURL, prefs string, r monthlyReport, u unitPrefs, l locale) {
+	id := fmt.Sprintf("%s/reports/monthly?year=%d&month=%d", baseURL, r.Start.Year(), int(r.Start.Month()))
+	reportURL := id
+	if prefs != "" {
+		reportURL += "&" + prefs
+	}
+	energy := u.EnergyUnit(r.EnergyYield * 1e3)
+	yield := l.Num(energy.FromKWh(r.EnergyYield*1e3), energy.Decimals)
+	summary := l.T("%s %s, capacity factor %s%%", yield, energy.Name, l.Num(r.CapacityFactor, 1))
+	if r.YoyChange != 0 {
+		yoy := l.Num(r.YoyChange, 1)
+		if r.YoyChange > 0 {
+			yoy = "+" + yoy
+		}
+		summary += l.T(", %s%% vs %s", yoy, l.Date(r.Start.AddDate(-1, 0, 0), "Jan 2006"))
+	}
+	if len(r.Days) > 0 {
+		best := r.Days[0]
+		for _, d := range r.Days {
+			if d.EnergyYield > best.EnergyYield {
+				best = d
+			}
+		}
+		dailyUnit := u.EnergyUnit(best.EnergyYield)
+		summary += l.T(". Best day %s with %s %s", l.Date(best.Date, "2 Jan"), l.Num(dailyUnit.FromKWh(best.EnergyYield), dailyUnit.Decimals), dailyUnit.Name)
+	}
+
+	fmt.Fprint(w, "<entry>\n")
+	fmt.Fprintf(w, "<title>%s</title>\n", xmlEscape(fmt.Sprintf("%s: %s %s", l.Date(r.Start, "January 2006"), yield, energy.Name)))
+	fmt.Fprintf(w, "<id>%s</id>\n", xmlEscape(id))
+	fmt.Fprintf(w, "<link rel=\"alternate\" type=\"application/pdf\" href=\"%s\"/>\n", xmlEscape(reportURL))
+	fmt.Fprintf(w, "<updated>%s</updated>\n", r.Start.AddDate(0, 1, 0).Format(time.RFC3339))
+	fmt.Fprintf(w, "<summary>%s.</summary>\n", xmlEscape(summary))
+	fmt.Fprint(w, "</entry>\n")
+}
+
+// feed publishes the completed months as an Atom feed, newest first.
+func feed(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	baseURL := "https://" + r.Host
+	u, l := getUnitPrefs(r), negotiateLocale(r)
+	prefs := prefQuery(r).Encode()
+	now := time.Now()
+	currentMonthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var reports []monthlyReport
+	for i := 1; i <= feedMonths; i++ {
+		start := currentMonthStart.AddDate(0, -i, 0)
+		report, err := getMonthlyReport(ctx, start.Year(), int(start.Month()))
+		if err != nil {
+			httpError(ctx, w, fsthttp.StatusInternalServerError, "Error building feed", err)
+			return
+		}
+		reports = append(reports, report)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Add("Vary", "Accept-Language")
+	fmt.Fprint(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	fmt.Fprintf(w, "<feed xmlns=\"http://www.w3.org/2005/Atom\" xml:lang=\"%s\">\n", l.Lang)
+	fmt.Fprintf(w, "<title>%s</title>\n", xmlEscape(l.T("Wind Turbine %s Monthly Production", TID)))
+	fmt.Fprintf(w, "<id>%s/feed.xml</id>\n", xmlEscape(baseURL))
+	self := baseURL + "/feed.xml"
+	if prefs != "" {
+		self += "?" + prefs
+	}
+	fmt.Fprintf(w, "<link rel=\"self\" href=\"%s\"/>\n", xmlEscape(self))
+	fmt.Fprintf(w, "<link rel=\"alternate\" type=\"text/html\" href=\"%s/\"/>\n", xmlEscape(baseURL))
+	fmt.Fprintf(w, "<updated>%s</updated>\n", currentMonthStart.Format(time.RFC3339))
+	fmt.Fprintf(w, "<author><name>Wind Turbine %s</name></author>\n", TID)
+	for _, report := range reports {
+		writeFeedEntry(w, baseURL, prefs, report, u, l)
+	}
+	fmt.Fprint(w, "</feed>\n")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// glyphs is a 5x7 bitmap font for drawing text into images without a font
+// renderer. Only the characters the images need are included, lower case is
+// drawn as upper case.
+var glyphs = map[rune][7]string{
+	' ': {"     ", "     ", "     ", "     ", "     ", "     ", "     "},
+	'0': {" ### ", "#   #", "#  ##", "# # #", "##  #", "#   #", " ### "},
+	'1': {"  #  ", " ##  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'2': {" ### ", "#   #", "    #", "   # ", "  #  ", " #   ", "#####"},
+	'3': {"#####", "   # ", "  #  ", "   # ", "    #", "#   #", " ### "},
+	'4': {"   # ", "  ## ", " # # ", "#  # ", "#####", "   # ", "   # "},
+	'5': {"#####", "#    ", "#### ", "    #", "    #", "#   #", " ### "},
+	'6': {"  ## ", " #   ", "#    ", "#### ", "#   #", "#   #", " ### "},
+	'7': {"#####", "    #", "   # ", "  #  ", " #   ", " #   ", " #   "},
+	'8': {" ### ", "#   #", "#   #", " ### ", "#   #", "#   #", " ### "},
+	'9': {" ### ", "#   #", "#   #", " ####", "    #", "   # ", " ##  "},
+	'A': {" ### ", "#   #", "#   #", "#####", "#   #", "#   #", "#   #"},
+	'B': {"#### ", "#   #", "#   #", "#### ", "#   #", "#   #", "#### "},
+	'C': {" ### ", "#   #", "#    ", "#    ", "#    ", "#   #", " ### "},
+	'D': {"#### ", "#   #", "#   #", "#   #", "#   #", "#   #", "#### "},
+	'E': {"#####", "#    ", "#    ", "#### ", "#    ", "#    ", "#####"},
+	'F': {"#####", "#    ", "#    ", "#### ", "#    ", "#    ", "#    "},
+	'G': {" ### ", "#   #", "#    ", "# ###", "#   #", "#   #", " ####"},
+	'H': {"#   #", "#   #", "#   #", "#####", "#   #", "#   #", "#   #"},
+	'I': {" ### ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'J': {"  ###", "   # ", "   # ", "   # ", "   # ", "#  # ", " ##  "},
+	'K': {"#   #", "#  # ", "# #  ", "##   ", "# #  ", "#  # ", "#   #"},
+	'L': {"#    ", "#    ", "#    ", "#    ", "#    ", "#    ", "#####"},
+	'M': {"#   #", "## ##", "# # #", "# # #", "#   #", "#   #", "#   #"},
+	'N': {"#   #", "#   #", "##  #", "# # #", "#  ##", "#   #", "#   #"},
+	'O': {" ### ", "#   #", "#   #", "#   #", "#   #", "#   #", " ### "},
+	'P': {"#### ", "#   #", "#   #", "#### ", "#    ", "#    ", "#    "},
+	'Q': {" ### ", "#   #", "#   #", "#   #", "# # #", "#  # ", " ## #"},
+	'R': {"#### ", "#   #", "#   #", "#### ", "# #  ", "#  # ", "#   #"},
+	'S': {" ####", "#    ", "#    ", " ### ", "    #", "    #", "#### "},
+	'T': {"#####", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  "},
+	'U': {"#   #", "#   #", "#   #", "#   #", "#   #", "#   #", " ### "},
+	'V': {"#   #", "#   #", "#   #", "#   #", "#   #", " # # ", "  #  "},
+	'W': {"#   #", "#   #", "#   #", "# # #", "# # #", "# # #", " # # "},
+	'X': {"#   #", "#   #", " # # ", "  #  ", " # # ", "#   #", "#   #"},
+	'Y': {"#   #", "#   #", " # # ", "  #  ", "  #  ", "  #  ", "  #  "},
+	'Z': {"#####", "    #", "   # ", "  #  ", " #   ", "#    ", "#####"},
+	'Ä': {"#   #", " ### ", "#   #", "#   #", "#####", "#   #", "#   #"},
+	'Ö': {"#   #", " ### ", "#   #", "#   #", "#   #", "#   #", " ### "},
+	'Ü': {"#   #", "     ", "#   #", "#   #", "#   #", "#   #", " ### "},
+	'.': {"     ", "     ", "     ", "     ", "     ", " ##  ", " ##  "},
+	',': {"     ", "     ", "     ", "     ", " ##  ", "  #  ", " #   "},
+	':': {"     ", " ##  ", " ##  ", "     ", " ##  ", " ##  ", "     "},
+	'%': {"##   ", "##  #", "   # ", "  #  ", " #   ", "#  ##", "   ##"},
+	'+': {"     ", "  #  ", "  #  ", "#####", "  #  ", "  #  ", "     "},
+	'-': {"     ", "     ", "     ", "#####", "     ", "     ", "     "},
+	'/': {"     ", "    #", "   # ", "  #  ", " #   ", "#    ", "     "},
+}
+
+// drawText draws s with its top left corner at x, y, each font pixel scaled
+// to a scale x scale block.
+func drawText(img *image.Paletted, x, y, scale int, c color.Color, s string) {
+	idx := uint8(img.Palette.Index(c))
+	for _, r := range strings.ToUpper(s) {
+		g, ok := glyphs[r]
+		if !ok {
+			g = glyphs[' ']
+		}
+		for row, line := range g {
+			for col, px := range line {
+				if px != '#' {
+					continue
+				}
+				fillRect(img, x+col*scale, y+row*scale, scale, scale, idx)
+			}
+		}
+		x += 6 * scale
+	}
+}
+
+// textWidth is the width drawText uses for s, for aligning text to its end.
+func textWidth(s string, scale int) int {
+	return len([]rune(s)) * 6 * scale
+}
+
+func fillRect(img *image.Paletted, x, y, w, h int, idx uint8) {
+	r := image.Rect(x, y, x+w, y+h).Intersect(img.Rect)
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			img.SetColorIndex(px, py, idx)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+)
+
+const defaultLang = "en"
+
+// locale formats text, numbers and dates for one language. English is the
+// source language, so its catalog is empty and every message falls through.
+type locale struct {
+	Lang        string
+	Decimal     string
+	Months      [12]string
+	ShortMonths [12]string
+	Days        [7]string
+	ShortDays   [7]string
+	Messages    map[string]string
+}
+
+var locales = map[string]locale{
+	"en": {Lang: "en", Decimal: "."},
+	"de": {
+		Lang:        "de",
+		Decimal:     ",",
+		Months:      [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		ShortMonths: [12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."},
+		Days:        [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		ShortDays:   [7]string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"},
+		Messages:    messagesDE,
+	},
+}
+
+// messagesDE is the German catalog, keyed by the English text. Date layouts
+// are translated too, since the order and punctuation differ.
+var messagesDE = map[string]string{
+	// Date layouts
+	"2 Jan":                "2. Jan",
+	"2 Jan 2006 15:04 MST": "2. Jan 2006 15:04 MST",
+	"2 Jan 2006 15:04":     "2. Jan 2006 15:04",
+	"2 Jan 15:04":          "2. Jan 15:04",
+	time.UnixDate:          "Mon, 2. Jan 2006 15:04:05 MST",
+
+	// Dashboard
+	"Wind Turbine Dashboard":                 "Windenergieanlagen-Dashboard",
+	"Turbine %s: %s kW now, %s %s this year": "Anlage %s: aktuell %s kW, %s %s in diesem Jahr",
+	"Monthly Production":                     "Monatliche Produktion",
+	"Last updated:":                          "Zuletzt aktualisiert:",
+	"(cached %ds ago)":                       "(vor %d s zwischengespeichert)",
+	"Power Output":                           "Leistung",
+	"% of capacity":                          "% der Nennleistung",
+	"Wind Speed":                             "Windgeschwindigkeit",
+	"Energy Today":                           "Energie heute",
+	"Year to Date":                           "Seit Jahresbeginn",
+	"vs YTD":                                 "ggü. Vorjahreszeitraum",
+	"Daily Energy Production":                "Tägliche Energieproduktion",
+	"Availability & Low Wind":                "Verfügbarkeit & Schwachwind",
+	"Monthly Energy Production (Last 12 Months)": "Monatliche Energieproduktion (letzte 12 Monate)",
+	"Yearly Energy Production (Since 2022)":      "Jährliche Energieproduktion (seit 2022)",
+	"Report":                                     "Bericht",
+	"Turbine Events":                             "Anlagenereignisse",
+	"code":                                       "Code",
+	"No status changes recorded yet.":            "Noch keine Statusänderungen erfasst.",
+	"Built by":                                   "Erstellt von",
+	"with":                                       "mit",
+	"and powered by":                             "und betrieben mit",
+	"Source":                                     "Quellcode",
+	"Units":                                      "Einheiten",
+
+	// Degraded dashboard and error page
+	"Data unavailable": "Daten nicht verfügbar",
+	"Some data could not be refreshed. Showing the last good values from %s.": "Einige Daten konnten nicht aktualisiert werden. Angezeigt werden die letzten gültigen Werte vom %s.",
+	"Dashboard unavailable": "Dashboard nicht verfügbar",
+	"The turbine data could not be loaded right now. Please try again in a few minutes.": "Die Anlagendaten konnten gerade nicht geladen werden. Bitte versuchen Sie es in ein paar Minuten erneut.",
+	"This page could not be shown.": "Diese Seite konnte nicht angezeigt werden.",
+	"Error %d":                      "Fehler %d",
+	"Request ID":                    "Anfrage-ID",
+	"Try again":                     "Erneut versuchen",
+
+	// Turbine states
+	stateRunning:     "in Betrieb",
+	stateStopped:     "gestoppt",
+	stateError:       "Störung",
+	stateMaintenance: "Wartung",
+
+	// Charts
+	"Average Wind Speed (%s)":        "Mittlere Windgeschwindigkeit (%s)",
+	"Max Wind Speed (%s)":            "Maximale Windgeschwindigkeit (%s)",
+	"Availability %":                 "Verfügbarkeit %",
+	"Low Wind Time %":                "Schwachwindzeit %",
+	"Energy Production (%s)":         "Energieproduktion (%s)",
+	"Monthly Energy Production (%s)": "Monatliche Energieproduktion (%s)",
+	"Yearly Energy Production (%s)":  "Jährliche Energieproduktion (%s)",
+	"Capacity Factor":                "Kapazitätsfaktor",
+	"YoY":                            "Vorjahr",
+	"(Incomplete - Current Month)":   "(unvollständig - laufender Monat)",
+
+	// Exports
+	"Month":               "Monat",
+	"Year":                "Jahr",
+	"Date":                "Datum",
+	"Energy (%s)":         "Energie (%s)",
+	"Capacity Factor (%)": "Kapazitätsfaktor (%)",
+	"YoY Change (%)":      "Änderung ggü. Vorjahr (%)",
+	"Wind Avg (%s)":       "Wind Mittel (%s)",
+	"Wind Max (%s)":       "Wind Max (%s)",
+	"Availability (%)":    "Verfügbarkeit (%)",
+	"Low Wind Time (s)":   "Schwachwindzeit (s)",
+
+	// Monthly report and feed
+	"Monthly Production Report":          "Monatlicher Produktionsbericht",
+	"Turbine %s - %s":                    "Anlage %s - %s",
+	"Energy":                             "Energie",
+	"vs %s":                              "ggü. %s",
+	"n/a":                                "k. A.",
+	"Daily Energy (%s)":                  "Tägliche Energie (%s)",
+	"No production data":                 "Keine Produktionsdaten",
+	"Availability":                       "Verfügbarkeit",
+	"Mean availability: %s %%":           "Mittlere Verfügbarkeit: %s %%",
+	"Lowest day: %s at %s %%":            "Schwächster Tag: %s mit %s %%",
+	"Low wind time: %s hours":            "Schwachwindzeit: %s Stunden",
+	"Notable Events":                     "Besondere Ereignisse",
+	"No status changes recorded.":        "Keine Statusänderungen erfasst.",
+	"... and %d more":                    "... und %d weitere",
+	"Generated %s":                       "Erstellt %s",
+	"Charts:":                            "Diagramme:",
+	"Last 30 Days":                       "Letzte 30 Tage",
+	"Last 12 Months":                     "Letzte 12 Monate",
+	"Every Year":                         "Alle Jahre",
+	"Wind Turbine %s Monthly Production": "Windenergieanlage %s - Monatliche Produktion",
+	"%s %s, capacity factor %s%%":        "%s %s, Kapazitätsfaktor %s %%",
+	", %s%% vs %s":                       ", %s %% ggü. %s",
+	". Best day %s with %s %s":           ". Bester Tag %s mit %s %s",
+
+	// Preview image
+	"Wind Turbine %s":        "Windenergieanlage %s",
+	"Current Power":          "Aktuelle Leistung",
+	"Year to Date %d":        "Seit Jahresbeginn %d",
+	"%s%% vs %d":             "%s %% ggü. %d",
+	"%s%% of %s kW capacity": "%s %% von %s kW Nennleistung",
+	"Updated %s":             "Aktualisiert %s",
+}
+
+// negotiateLocale picks the locale from the lang query parameter, then the
+// Accept-Language header, then falls back to English. Responses that use it
+// must vary on Accept-Language.
+func negotiateLocale(r *fsthttp.Request) locale {
+	if l, ok := locales[r.URL.Query().Get("lang")]; ok {
+		return l
+	}
+	best, bestQ := defaultLang, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if _, ok := locales[lang]; !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return locales[best]
+}
+
+// T translates an English message, formatting it with args if given.
+func (l locale) T(msg string, args ...any) string {
+	if s, ok := l.Messages[msg]; ok {
+		msg = s
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Num formats v with a fixed number of decimals and the locale's decimal
+// separator. There is no digit grouping so the output stays parseable in
+// spreadsheets.
+func (l locale) Num(v float64, decimals int) string {
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	if l.Decimal != "." {
+		s = strings.Replace(s, ".", l.Decimal, 1)
+	}
+	return s
+}
+
+// CSVSeparator is a semicolon where the decimal separator is a comma, which
+// is what spreadsheet apps in those locales expect.
+func (l locale) CSVSeparator() string {
+	if l.Decimal == "," {
+		return ";"
+	}
+	return ","
+}
+
+// csvHeaders sets the headers for a localized CSV download. Excel only reads
+// a CSV as UTF-8 if it starts with a byte order mark, which the translated
+// headers need.
+func csvHeaders(w fsthttp.ResponseWriter, l locale, filename string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	w.Header().Add("Vary", "Accept-Language")
+	if l.Lang != defaultLang {
+		io.WriteString(w, "\ufeff")
+	}
+}
+
+// writeCSVRow writes already formatted fields with the locale's separator,
+// quoting any that contain it.
+func writeCSVRow(w io.Writer, l locale, fields ...string) error {
+	sep := l.CSVSeparator()
+	for i, f := range fields {
+		if strings.ContainsAny(f, sep+"\"\n") {
+			fields[i] = `"` + strings.ReplaceAll(f, `"`, `""`) + `"`
+		}
+	}
+	_, err := io.WriteString(w, strings.Join(fields, sep)+"\n")
+	return err
+}
+
+// Date formats t with a Go layout, translating the layout and the month and
+// day names.
+func (l locale) Date(t time.Time, layout string) string {
+	layout = l.T(layout)
+	if l.Months[0] == "" {
+		return t.Format(layout)
+	}
+	var b strings.Builder
+	for layout != "" {
+		switch {
+		case strings.HasPrefix(layout, "January"):
+			b.WriteString(l.Months[t.Month()-1])
+			layout = layout[len("January"):]
+		case strings.HasPrefix(layout, "Jan"):
+			b.WriteString(l.ShortMonths[t.Month()-1])
+			layout = layout[len("Jan"):]
+		case strings.HasPrefix(layout, "Monday"):
+			b.WriteString(l.Days[t.Weekday()])
+			layout = layout[len("Monday"):]
+		case strings.HasPrefix(layout, "Mon"):
+			b.WriteString(l.ShortDays[t.Weekday()])
+			layout = layout[len("Mon"):]
+		default:
+			// Format everything up to the next name with the standard layout
+			i := 1
+			for i < len(layout) && !strings.HasPrefix(layout[i:], "Jan") && !strings.HasPrefix(layout[i:], "Mon") {
+				i++
+			}
+			b.WriteString(t.Format(layout[:i]))
+			layout = layout[i:]
+		}
+	}
+	return b.String()
+}
+
+// MonthLabel re-formats a "Jan 2006" label from the monthly aggregates.
+func (l locale) MonthLabel(label string) string {
+	t, err := time.Parse("Jan 2006", label)
+	if err != nil {
+		return label
+	}
+	return l.Date(t, "Jan 2006")
+}
+
+// templateContext adds the translation helpers to a pongo2 context.
+func (l locale) templateContext() map[string]any {
+	return map[string]any{
+		"lang":    l.Lang,
+		"t":       l.T,
+		"num":     l.Num,
+		"fmtDate": l.Date,
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/fsttest"
+)
+
+func TestNegotiateLocale(t *testing.T) {
+	tests := []struct {
+		query, acceptLanguage string
+		want                  string
+	}{
+		{"", "", "en"},
+		{"", "de", "de"},
+		{"", "de-DE,de;q=0.9,en;q=0.8", "de"},
+		{"", "DE-at", "de"},
+		{"", "en;q=0.5, de;q=0.8", "de"},
+		{"", "en, de", "en"},
+		{"", "fr-FR, fr;q=0.9, de;q=0.1", "de"},
+		{"", "de;q=0", "en"},
+		{"", "de;q=abc", "de"},
+		{"", "fr-FR, it", "en"},
+		{"?lang=de", "en", "de"},
+		{"?lang=en", "de", "en"},
+		{"?lang=fr", "de", "de"},
+		{"?lang=fr", "fr", "en"},
+	}
+	for _, tt := range tests {
+		r, err := fsthttp.NewRequest("GET", "https://example.com/"+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.acceptLanguage != "" {
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		if got := negotiateLocale(r).Lang; got != tt.want {
+			t.Errorf("negotiateLocale(%q, Accept-Language %q) = %q, want %q", tt.query, tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+func TestLocaleDate(t *testing.T) {
+	jan := time.Date(2025, 1, 2, 9, 5, 7, 0, time.UTC)
+	mar := time.Date(2025, 3, 3, 18, 30, 0, 0, time.UTC)
+	tests := []struct {
+		lang   string
+		t      time.Time
+		layout string
+		want   string
+	}{
+		{"en", jan, "2 Jan", "2 Jan"},
+		{"de", jan, "2 Jan", "2. Jan."},
+		{"de", mar, "2 Jan", "3. März"},
+		{"de", time.Date(2025, 5, 31, 0, 0, 0, 0, time.UTC), "2 Jan", "31. Mai"},
+		{"de", jan, "2 Jan 2006 15:04 MST", "2. Jan. 2025 09:05 UTC"},
+		{"en", mar, time.UnixDate, "Mon Mar  3 18:30:00 UTC 2025"},
+		{"de", mar, time.UnixDate, "Mo, 3. März 2025 18:30:00 UTC"},
+		{"de", mar, "Monday, 2 January 2006", "Montag, 3 März 2025"},
+	}
+	for _, tt := range tests {
+		if got := locales[tt.lang].Date(tt.t, tt.layout); got != tt.want {
+			t.Errorf("%s Date(%s, %q) = %q, want %q", tt.lang, tt.t.Format(time.DateOnly), tt.layout, got, tt.want)
+		}
+	}
+}
+
+func TestLocaleMonthLabel(t *testing.T) {
+	tests := []struct {
+		lang, label, want string
+	}{
+		{"en", "Jan 2025", "Jan 2025"},
+		{"de", "Jan 2025", "Jan. 2025"},
+		{"de", "Mar 2025", "März 2025"},
+		{"de", "Sep 2024", "Sept. 2024"},
+		{"de", "Dec 2024", "Dez. 2024"},
+		{"de", "not a month", "not a month"},
+	}
+	for _, tt := range tests {
+		if got := locales[tt.lang].MonthLabel(tt.label); got != tt.want {
+			t.Errorf("%s MonthLabel(%q) = %q, want %q", tt.lang, tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestLocaleNum(t *testing.T) {
+	tests := []struct {
+		lang     string
+		v        float64
+		decimals int
+		want     string
+	}{
+		{"en", 1234.5, 2, "1234.50"},
+		{"de", 1234.5, 2, "1234,50"},
+		{"de", 12.5, 1, "12,5"},
+		{"de", -0.25, 2, "-0,25"},
+		{"de", 3.14159, 0, "3"},
+		{"en", 1e6, 1, "1000000.0"},
+		{"de", 1e6, 1, "1000000,0"},
+	}
+	for _, tt := range tests {
+		if got := locales[tt.lang].Num(tt.v, tt.decimals); got != tt.want {
+			t.Errorf("%s Num(%v, %d) = %q, want %q", tt.lang, tt.v, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestWriteCSVRow(t *testing.T) {
+	tests := []struct {
+		lang   string
+		fields []string
+		want   string
+	}{
+		{"en", []string{"2025-01-02", "1234.50", "7.25"}, "2025-01-02,1234.50,7.25\n"},
+		{"de", []string{"2025-01-02", "1234,50", "7,25"}, "2025-01-02;1234,50;7,25\n"},
+		{"en", []string{"Energy (MWh)", "a,b"}, "Energy (MWh),\"a,b\"\n"},
+		{"de", []string{"a,b", "c;d"}, "a,b;\"c;d\"\n"},
+		{"de", []string{`say "hi"`, "two\nlines"}, "\"say \"\"hi\"\"\";\"two\nlines\"\n"},
+		{"en", []string{""}, "\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		if err := writeCSVRow(&b, locales[tt.lang], tt.fields...); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != tt.want {
+			t.Errorf("%s writeCSVRow(%q) = %q, want %q", tt.lang, tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestCSVHeaders(t *testing.T) {
+	tests := []struct {
+		lang string
+		body string
+	}{
+		{"en", ""},
+		{"de", "\ufeff"},
+	}
+	for _, tt := range tests {
+		w := fsttest.NewRecorder()
+		w.Header().Set("Vary", "Accept")
+		csvHeaders(w, locales[tt.lang], "export.csv")
+		if got := w.Body.String(); got != tt.body {
+			t.Errorf("%s body = %q, want %q", tt.lang, got, tt.body)
+		}
+		if got := w.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+			t.Errorf("%s Content-Type = %q", tt.lang, got)
+		}
+		if got := w.Header().Get("Content-Disposition"); got != "attachment; filename=export.csv" {
+			t.Errorf("%s Content-Disposition = %q", tt.lang, got)
+		}
+		if got := w.Header().Values("Vary"); len(got) != 2 || got[0] != "Accept" || got[1] != "Accept-Language" {
+			t.Errorf("%s Vary = %q, want Accept and Accept-Language", tt.lang, got)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/valyala/fastjson"
+)
+
+const (
+	// Compute caps how long a request can run, so each connection is kept
+	// short and the browser's EventSource reconnects on its own.
+	liveStreamDuration = 100 * time.Second
+	livePollInterval   = 20 * time.Second
+	liveRetry          = 5000 // ms
+)
+
+// liveStream is a Server-Sent Events feed that pushes the newest MeanData slot
+// whenever one becomes available.
+func liveStream(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(fsthttp.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", liveRetry)
+	u := getUnitPrefs(r)
+
+	// A reconnecting client tells us which slot it has already seen
+	var lastSlot int64
+	if id, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		lastSlot = id
+	}
+
+	deadline := time.Now().Add(liveStreamDuration)
+	for {
+		slot := latestMeanSlot().Unix()
+		if slot != lastSlot {
+			data, err := liveSnapshot(ctx, u)
+			if err != nil {
+				logFor(ctx).Error("live snapshot", err)
+			} else {
+				if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", slot, data); err != nil {
+					return
+				}
+				lastSlot = slot
+			}
+		} else if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+			return
+		}
+
+		if time.Now().Add(livePollInterval).After(deadline) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(livePollInterval):
+		}
+	}
+}
+
+// liveSnapshot returns the newest MeanData values as the JSON sent to clients,
+// with the wind speed in the preferred unit. There is no energy value to
+// convert.
+func liveSnapshot(ctx context.Context, u unitPrefs) ([]byte, error) {
+	latestMean, _, err := getLatestMean(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, err := fastjson.Parse(latestMean)
+	if err != nil {
+		return nil, err
+	}
+	data := v.GetArray("data")
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no mean data for slot %s", latestMeanSlot().Format(time.RFC3339))
+	}
+	latest := data[len(data)-1]
+	powerAvg := latest.GetFloat64("powerAvg")
+	powerPct := powerAvg / powerNominal * 100
+
+	var a fastjson.Arena
+	o := a.NewObject()
+	o.Set("powerAvg", a.NewNumberFloat64(powerAvg))
+	o.Set("powerAvgPct", a.NewNumberFloat64(powerPct))
+	o.Set("spinDuration", a.NewNumberFloat64(spinDurationFor(powerPct)))
+	o.Set("windAvg", a.NewNumberFloat64(u.WindSpeed(latest.GetFloat64("windAvg"))))
+	units := a.NewObject()
+	units.Set("wind", a.NewString(u.Wind.Name))
+	o.Set("units", units)
+	return o.MarshalTo(nil), nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fastjson"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+	"github.com/fastly/compute-sdk-go/rtlog"
+)
+
+// requestLog writes JSON lines tagged with the request ID and route, so one
+// request can be followed through its upstream calls and KV lookups. Lines go
+// to the real-time logging endpoint named by log-endpoint in the config
+// store, or to stdout for log tailing if there is none.
+type requestLog struct {
+	ID      string
+	Route   string
+	out     io.Writer
+	newline bool
+}
+
+type requestLogKey struct{}
+
+func newRequestLog(r *fsthttp.Request) *requestLog {
+	l := &requestLog{ID: requestID(r), Route: r.URL.Path, out: os.Stdout, newline: true}
+	if name := getConfig("log-endpoint", ""); name != "" {
+		l.out = rtlog.Open(name)
+		l.newline = false
+	}
+	return l
+}
+
+// requestID reuses an X-Request-ID from a proxy in front of us if it looks
+// sane, then Fastly's trace ID, then a random one. It is empty if no random
+// bytes could be read.
+func requestID(r *fsthttp.Request) string {
+	if id := r.Header.Get("X-Request-ID"); validRequestID(id) {
+		return id
+	}
+	if id := os.Getenv("FASTLY_TRACE_ID"); id != "" {
+		return id
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// logFor returns the request's log, or an untagged one writing to stdout
+// outside a request.
+func logFor(ctx context.Context) *requestLog {
+	if l, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		return l
+	}
+	return &requestLog{out: os.Stdout, newline: true}
+}
+
+// Info logs msg with alternating key and value fields.
+func (l *requestLog) Info(msg string, kv ...any) {
+	l.write("info", msg, kv)
+}
+
+// Warn logs something that went wrong but was handled.
+func (l *requestLog) Warn(msg string, err error, kv ...any) {
+	l.write("warn", msg, append(kv, "error", err))
+}
+
+// Error logs err with msg and fields.
+func (l *requestLog) Error(msg string, err error, kv ...any) {
+	l.write("error", msg, append(kv, "error", err))
+}
+
+func (l *requestLog) write(level, msg string, kv []any) {
+	var a fastjson.Arena
+	o := a.NewObject()
+	o.Set("time", a.NewString(time.Now().UTC().Format(time.RFC3339Nano)))
+	o.Set("level", a.NewString(level))
+	if l.ID != "" {
+		o.Set("requestId", a.NewString(l.ID))
+	}
+	if l.Route != "" {
+		o.Set("route", a.NewString(l.Route))
+	}
+	o.Set("msg", a.NewString(msg))
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, _ := kv[i].(string)
+		var v *fastjson.Value
+		switch x := kv[i+1].(type) {
+		case nil:
+			continue
+		case string:
+			v = a.NewString(x)
+		case int:
+			v = a.NewNumberInt(x)
+		case int64:
+			v = a.NewNumberString(strconv.FormatInt(x, 10))
+		case uint32:
+			v = a.NewNumberInt(int(x))
+		case float64:
+			v = a.NewNumberFloat64(x)
+		case bool:
+			v = a.NewFalse()
+			if x {
+				v = a.NewTrue()
+			}
+		case error:
+			v = a.NewString(x.Error())
+		default:
+			v = a.NewString(fmt.Sprint(x))
+		}
+		o.Set(key, v)
+	}
+	b := o.MarshalTo(nil)
+	if l.newline {
+		b = append(b, '\n')
+	}
+	l.out.Write(b)
+}
+
+// millis is a duration in milliseconds for the durationMs fields.
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// statusWriter remembers the status code for the request log.
+type statusWriter struct {
+	fsthttp.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = fsthttp.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// traceRequest sets up the log for a request and echoes its ID in the
+// X-Request-ID header. The returned function logs the finished request.
+func traceRequest(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) (context.Context, fsthttp.ResponseWriter, func()) {
+	l := newRequestLog(r)
+	sw := &statusWriter{ResponseWriter: w}
+	sw.Header().Set("X-Request-ID", l.ID)
+	start := time.Now()
+	return context.WithValue(ctx, requestLogKey{}, l), sw, func() {
+		status := sw.status
+		if status == 0 {
+			status = fsthttp.StatusOK
+		}
+		l.Info("request", "method", r.Method, "status", status, "durationMs", millis(time.Since(start)))
+	}
+}
+
+// httpError logs err and sends status with msg and the request ID, so a
+// visitor's report can be matched to the log. err itself is only logged.
+func httpError(ctx context.Context, w fsthttp.ResponseWriter, status int, msg string, err error) {
+	l := logFor(ctx)
+	l.Error(msg, err, "status", status)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s\nRequest ID: %s\n", msg, l.ID)
+}
+
+// send sends req to backend, with retries and the circuit breaker, and logs
+// each attempt. The request ID is passed on so the other side's logs can be
+// matched too.
+func send(ctx context.Context, req *fsthttp.Request, backend string) (*fsthttp.Response, error) {
+	if id := logFor(ctx).ID; id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	return sendWithRetry(ctx, req, backend)
+}
+
+// kvLookup looks key up in store and logs whether it was a hit.
+func kvLookup(ctx context.Context, store *kvstore.Store, key string) (*kvstore.Entry, error) {
+	start := time.Now()
+	entry, err := store.Lookup(key)
+	kv := []any{"kvKey", key, "cache", "hit", "durationMs", millis(time.Since(start))}
+	switch {
+	case errors.Is(err, kvstore.ErrKeyNotFound):
+		kv[3] = "miss"
+		logFor(ctx).Info("kv lookup", kv...)
+	case err != nil:
+		kv[3] = "miss"
+		logFor(ctx).Error("kv lookup", err, kv...)
+	default:
+		logFor(ctx).Info("kv lookup", kv...)
+	}
+	return entry, err
+}
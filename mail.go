@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+	"github.com/fastly/compute-sdk-go/secretstore"
+	"github.com/valyala/fastjson"
+)
+
+const (
+	mailSecretName     = "mail-api-key"
+	mailOutboxKey      = "mail-outbox"
+	maxOutboxCount     = 20
+	defaultMailFrom    = "windash@example.com"
+	mailBackendHTTP    = "http"
+	mailBackendCapture = "capture"
+)
+
+// mailMessage is an email with both a plain text and an HTML body.
+type mailMessage struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// mailer sends email. The backend is picked by mail-backend in the config
+// store, so local development can capture messages instead of sending them.
+type mailer interface {
+	Send(ctx context.Context, m mailMessage) error
+}
+
+func newMailer() mailer {
+	if getConfig("mail-backend", mailBackendCapture) == mailBackendHTTP {
+		return httpMailer{
+			Backend: getConfig("mail-api-backend", "mail"),
+			URL:     getConfig("mail-api-url", ""),
+		}
+	}
+	return captureMailer{}
+}
+
+// mailRecipients returns the mail-to config setting as a list.
+func mailRecipients() []string {
+	var to []string
+	for _, addr := range strings.Split(getConfig("mail-to", ""), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	return to
+}
+
+func mailJSON(m mailMessage) []byte {
+	var a fastjson.Arena
+	to := a.NewArray()
+	for i, addr := range m.To {
+		to.SetArrayItem(i, a.NewString(addr))
+	}
+	o := a.NewObject()
+	o.Set("from", a.NewString(m.From))
+	o.Set("to", to)
+	o.Set("subject", a.NewString(m.Subject))
+	o.Set("text", a.NewString(m.Text))
+	o.Set("html", a.NewString(m.HTML))
+	return o.MarshalTo(nil)
+}
+
+// httpMailer posts messages as JSON ({from, to, subject, text, html}) to a
+// transactional mail API, authenticating with the mail-api-key secret as a
+// bearer token.
+type httpMailer struct {
+	Backend string
+	URL     string
+}
+
+func (h httpMailer) Send(ctx context.Context, m mailMessage) error {
+	if h.URL == "" {
+		return errors.New("mail: mail-api-url is not configured")
+	}
+	if len(m.To) == 0 {
+		return errors.New("mail: mail-to is not configured")
+	}
+	key, err := secretstore.Plaintext(secretStoreName, mailSecretName)
+	if err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	req, err := fsthttp.NewRequest("POST", h.URL, bytes.NewReader(mailJSON(m)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+string(key))
+	req.CacheOptions = fsthttp.CacheOptions{Pass: true}
+	resp, err := send(ctx, req, h.Backend)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("mail %s: %s", h.Backend, fsthttp.StatusText(resp.StatusCode))
+	}
+	return nil
+}
+
+// captureMailer keeps the newest messages in KV instead of sending them, for
+// local development and for checking the templates.
+type captureMailer struct{}
+
+func (captureMailer) Send(ctx context.Context, m mailMessage) error {
+	store, err := kvstore.Open(kvStoreName)
+	if err != nil {
+		return err
+	}
+	prev := ""
+	if entry, err := kvLookup(ctx, store, mailOutboxKey); err == nil {
+		prev = entry.String()
+	}
+	outbox, err := captureOutbox(prev, m, time.Now())
+	if err != nil {
+		return err
+	}
+	logFor(ctx).Info("mail captured", "subject", m.Subject, "to", strings.Join(m.To, ", "))
+	return store.Insert(mailOutboxKey, bytes.NewReader(outbox))
+}
+
+// captureOutbox puts m at the front of the captured outbox prev, keeping the
+// newest maxOutboxCount messages. An unreadable outbox is started afresh.
+func captureOutbox(prev string, m mailMessage, now time.Time) ([]byte, error) {
+	var outbox []*fastjson.Value
+	if v, err := fastjson.Parse(prev); err == nil {
+		outbox = v.GetArray()
+	}
+
+	var a fastjson.Arena
+	arr := a.NewArray()
+	msg, err := fastjson.ParseBytes(mailJSON(m))
+	if err != nil {
+		return nil, err
+	}
+	msg.Set("time", a.NewNumberInt(int(now.Unix())))
+	arr.SetArrayItem(0, msg)
+	for i, v := range outbox {
+		if i+1 == maxOutboxCount {
+			break
+		}
+		arr.SetArrayItem(i+1, v)
+	}
+	return arr.MarshalTo(nil), nil
+}
@@ -6,9 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	_ "embed"
@@ -54,7 +54,7 @@ func getKey() string {
 	if apiKey == "" {
 		abs, err := secretstore.Plaintext(secretStoreName, secretName)
 		if err != nil {
-			fmt.Println("secret not found")
+			logFor(context.Background()).Error("secret not found", err, "secret", secretName)
 		}
 		apiKey = string(abs)
 	}
@@ -63,16 +63,35 @@ func getKey() string {
 
 func main() {
 	// Log service version
-	fmt.Println("Service Version:", os.Getenv("FASTLY_SERVICE_VERSION"))
+	logFor(context.Background()).Info("service started", "version", os.Getenv("FASTLY_SERVICE_VERSION"))
 
 	fsthttp.ServeFunc(func(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
-		// Filter requests that have unexpected methods.
-		if r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH" || r.Method == "DELETE" {
+		ctx, w, done := traceRequest(ctx, w, r)
+		defer done()
+		w = &compressWriter{ResponseWriter: w}
+
+		// Filter requests that have unexpected methods. The internal
+		// endpoints take POST and DELETE for the changes they make.
+		internal := strings.HasPrefix(r.URL.Path, "/internal/")
+		if r.Method == "PUT" || r.Method == "PATCH" || (!internal && (r.Method == "POST" || r.Method == "DELETE")) {
 			w.WriteHeader(fsthttp.StatusMethodNotAllowed)
 			fmt.Fprintf(w, "This method is not allowed\n")
 			return
 		}
 
+		// Private dashboards need a login for everything but the icons, or
+		// an API key on the data API
+		keyed := false
+		if scope, ok := apiScopes[r.URL.Path]; ok {
+			if keyed, ok = requireAPIKey(ctx, w, r, scope); !ok {
+				return
+			}
+		}
+		w, ok := requireAccess(ctx, w, r, keyed)
+		if !ok {
+			return
+		}
+
 		if r.URL.Path == "/" {
 			index(ctx, w, r)
 			return
@@ -87,24 +106,38 @@ func main() {
 			io.Copy(w, bytes.NewReader(faviconSVGBytes))
 			return
 		}
+		if r.URL.Path == "/embed/live" {
+			embedLive(ctx, w, r)
+			return
+		}
+		if r.URL.Path == "/og.png" {
+			ogImage(ctx, w, r)
+			return
+		}
+		if r.URL.Path == "/badge.svg" {
+			badge(ctx, w, r)
+			return
+		}
 		if r.URL.Path == "/last30" {
 			data, err := last30(ctx)
 			if err != nil {
-				w.WriteHeader(fsthttp.StatusInternalServerError)
-				fmt.Println(err)
+				httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
 				return
 			}
-			fmt.Println(data)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "public, max-age=600")
+			fmt.Fprint(w, data)
 			return
 		}
 		if r.URL.Path == "/year" {
 			data, err := getYear(ctx, 2024)
 			if err != nil {
-				w.WriteHeader(fsthttp.StatusInternalServerError)
-				fmt.Println(err)
+				httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
 				return
 			}
-			fmt.Println(data)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "public, max-age=600")
+			fmt.Fprint(w, data)
 			return
 		}
 		if r.URL.Path == "/history" {
@@ -119,6 +152,50 @@ func main() {
 			exportYearly(ctx, w, r)
 			return
 		}
+		if r.URL.Path == "/export/daily" {
+			exportDaily(ctx, w, r)
+			return
+		}
+		if r.URL.Path == "/feed.xml" {
+			feed(ctx, w, r)
+			return
+		}
+		if r.URL.Path == "/reports/monthly" {
+			reportMonthly(ctx, w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/charts/") && strings.HasSuffix(r.URL.Path, ".svg") {
+			chart(ctx, w, r)
+			return
+		}
+		if r.URL.Path == "/api/v1/events" {
+			eventsAPI(ctx, w, r)
+			return
+		}
+		if r.URL.Path == "/live/stream" {
+			liveStream(ctx, w, r)
+			return
+		}
+		if r.URL.Path == "/metrics" {
+			metrics(ctx, w, r)
+			return
+		}
+		if r.URL.Path == "/internal/refresh" {
+			refresh(ctx, w, r)
+			return
+		}
+		if r.URL.Path == "/internal/purge" {
+			purgeKeys(ctx, w, r)
+			return
+		}
+		if r.URL.Path == "/internal/apikeys" {
+			apiKeys(ctx, w, r)
+			return
+		}
+		if r.URL.Path == "/internal/digest" {
+			digestMail(ctx, w, r)
+			return
+		}
 
 		// Catch all other requests and return a 404.
 		w.WriteHeader(fsthttp.StatusNotFound)
@@ -127,49 +204,36 @@ func main() {
 }
 
 func index(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
-	latestPerf, age, err := getLatestPerf(ctx)
-	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
-		return
-	}
-	latestMean, _, err := getLatestMean(ctx)
-	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
-		return
-	}
-	// fmt.Println(latestMean)
 	t, err := pongo2.FromString(indexTemplate)
 	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
+		errorPage(ctx, w, r, fsthttp.StatusInternalServerError, err)
 		return
 	}
-	par, err := fastjson.Parse(latestPerf)
-	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
-		return
+
+	// Each section renders on its own, from the last good copy if the API
+	// is failing, so one bad call doesn't take down the whole page
+	var state dashboardState
+	latestPerf, age, err := getLatestPerf(ctx)
+	if err == nil {
+		_, err = fastjson.Parse(latestPerf)
 	}
-	_, err = fastjson.Parse(latestMean)
-	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
-		return
+	latestPerf, perfStale, err := lastGood(ctx, "perf", latestPerf, err)
+	perfOK := state.track("perf", perfStale, err)
+	par, _ := fastjson.Parse(latestPerf)
+	if !perfStale.IsZero() {
+		age = uint32(time.Since(perfStale).Seconds())
 	}
-	last30, err := last30(ctx)
-	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
-		return
-	}
-	l30, err := fastjson.Parse(last30)
-	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
-		return
+
+	days, err := getLast30Days(ctx)
+	daysData, daysStale, err := lastGood(ctx, "last30", daysJSON(days), err)
+	if err == nil {
+		days, err = parseDays(daysData)
 	}
+	dailyOK := state.track("daily", daysStale, err)
+
+	l := negotiateLocale(r)
+	u := getUnitPrefs(r)
+	dailyUnit := u.EnergyUnit(maxDailyYield(days))
 	var windAvgArr [30]float64
 	var windMaxArr [30]float64
 	var energyYieldArr [30]float64
@@ -178,39 +242,32 @@ func index(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
 	var dayArr [30]string
 	now := time.Now()
 	yesterday := now.Add(-24 * time.Hour)
-	for i, day := range l30.GetArray("data") {
-		windAvgArr[i] = day.GetFloat64("windAvg")
-		windMaxArr[i] = day.GetFloat64("windMax")
-		availArr[i] = day.GetFloat64("availability")
-		lowWindArr[i] = day.GetFloat64("lowWindTime") / 86400 * 100
-		energyYieldArr[i] = day.GetFloat64("energyYield") / 1e3
-		d := yesterday.Add(time.Duration((-29+i)*24) * time.Hour)
-		dayArr[i] = d.Format("2 Jan")
+	for i, day := range days {
+		lowWindArr[i] = day.LowWindTime / 86400 * 100
+		day = convertDay(day, dailyUnit, u.Wind)
+		windAvgArr[i] = day.WindAvg
+		windMaxArr[i] = day.WindMax
+		availArr[i] = day.Avail
+		energyYieldArr[i] = day.EnergyYield
+		dayArr[i] = l.Date(day.Date, "2 Jan")
 	}
 
 	// Get monthly data
 	monthlyData, err := getLast12Months(ctx)
-	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
-		return
-	}
-	monthly, err := fastjson.Parse(monthlyData)
-	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
-		return
-	}
+	monthlyData, monthlyStale, err := lastGood(ctx, "monthly", monthlyData, err)
+	monthlyOK := state.track("monthly", monthlyStale, err)
+	monthly, _ := fastjson.Parse(monthlyData)
 	var monthlyLabelsArr [12]string
 	var monthlyYieldArr [12]float64
 	var monthlyIsCurrentArr [12]bool
 	var monthlyCapacityFactorArr [12]float64
 	var monthlyYoyChangeArr [12]float64
+	monthlyUnit := u.EnergyUnit(maxArray(monthly, "energyYield") * 1e3)
 	for i, m := range monthly.GetArray("months") {
-		monthlyLabelsArr[i] = string(m.GetStringBytes())
+		monthlyLabelsArr[i] = l.MonthLabel(string(m.GetStringBytes()))
 	}
 	for i, y := range monthly.GetArray("energyYield") {
-		monthlyYieldArr[i] = y.GetFloat64()
+		monthlyYieldArr[i] = monthlyUnit.FromKWh(y.GetFloat64() * 1e3)
 	}
 	for i, c := range monthly.GetArray("isCurrentMonth") {
 		monthlyIsCurrentArr[i] = c.GetBool()
@@ -224,17 +281,9 @@ func index(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
 
 	// Get yearly data
 	yearlyData, err := getYearsSince2020(ctx)
-	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
-		return
-	}
-	yearly, err := fastjson.Parse(yearlyData)
-	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
-		return
-	}
+	yearlyData, yearlyStale, err := lastGood(ctx, "yearly", yearlyData, err)
+	yearlyOK := state.track("yearly", yearlyStale, err)
+	yearly, _ := fastjson.Parse(yearlyData)
 
 	// Calculate dynamic array size (2022 to current year)
 	yearCount := time.Now().Year() - 2022 + 1
@@ -242,11 +291,12 @@ func index(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
 	yearlyYieldArr := make([]float64, yearCount)
 	yearlyCapacityFactorArr := make([]float64, yearCount)
 	yearlyYoyChangeArr := make([]float64, yearCount)
+	yearlyUnit := u.EnergyUnit(maxArray(yearly, "energyYield") * 1e6)
 	for i, y := range yearly.GetArray("years") {
 		yearlyLabelsArr[i] = string(y.GetStringBytes())
 	}
 	for i, y := range yearly.GetArray("energyYield") {
-		yearlyYieldArr[i] = y.GetFloat64()
+		yearlyYieldArr[i] = yearlyUnit.FromKWh(y.GetFloat64() * 1e6)
 	}
 	for i, cf := range yearly.GetArray("capacityFactor") {
 		yearlyCapacityFactorArr[i] = cf.GetFloat64()
@@ -257,59 +307,111 @@ func index(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
 
 	// Get year-to-date total
 	ytdTotal, err := getYearToDateTotal(ctx)
-	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
+	ytdData, ytdStale, err := lastGood(ctx, "ytd", strconv.FormatFloat(ytdTotal, 'f', -1, 64), err)
+	if err == nil {
+		ytdTotal, err = strconv.ParseFloat(ytdData, 64)
+	}
+	ytdOK := state.track("ytd", ytdStale, err)
+
+	if !perfOK && !dailyOK && !monthlyOK && !yearlyOK && !ytdOK {
+		errorPage(ctx, w, r, fsthttp.StatusServiceUnavailable, err)
 		return
 	}
 
 	// Calculate YTD year-over-year change
 	ytdYoyChange := 0.0
-	prevYearYTD, err := getYearToDateTotalForYear(ctx, time.Now().Year()-1, int(time.Now().Month()))
-	if err == nil && prevYearYTD > 0 {
-		ytdYoyChange = ((ytdTotal - prevYearYTD) / prevYearYTD) * 100
+	if ytdOK {
+		ytdYoyChange = getYtdYoyChange(ctx, ytdTotal)
+	}
+	ytdUnit := u.EnergyUnit(ytdTotal * 1e3)
+
+	// Last completed month for the PDF report link
+	lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+
+	// Status is best effort, the dashboard still renders without it
+	status, err := getCurrentStatus(ctx)
+	if err != nil {
+		logFor(ctx).Warn("status unavailable", err)
+	}
+	events, err := getEvents(ctx)
+	if err != nil {
+		logFor(ctx).Warn("events unavailable", err)
 	}
 
 	// fmt.Println("powerPct", par.GetFloat64("data", "0", "powerAvg")/powerNominal*100)
-	// Spin duration: 10s at 0% power, 0.5s at 100% power (linear interpolation)
 	powerPct := par.GetFloat64("data", "0", "powerAvg") / powerNominal * 100
-	spinDuration := 10.0 - (powerPct/100.0)*9.5
-	if spinDuration < 0.5 {
-		spinDuration = 0.5
+	spinDuration := spinDurationFor(powerPct)
+	energyToday := par.GetFloat64("data", "0", "energyYield")
+	energyTodayUnit := u.EnergyUnit(energyToday)
+
+	staleSince := ""
+	if !state.StaleSince.IsZero() {
+		staleSince = l.Date(state.StaleSince, "2 Jan 2006 15:04 MST")
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Header().Set("Cache-Control", "public, max-age=600")
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	setSurrogateKeys(w, []string{turbineKey("live")}, dayKeys(yesterday.AddDate(0, 0, -29), yesterday),
+		monthKeys(thisMonth.AddDate(-1, -11, 0), now), yearKeys(2021, now.Year()))
+	if state.Degraded() {
+		// Try again soon rather than caching the gaps
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("Surrogate-Control", "max-age=60")
+	}
+	w.Header().Set("Vary", "Accept-Language")
 	err = t.ExecuteWriter(pongo2.Context{
-		"energyYield":           par.GetFloat64("data", "0", "energyYield"),
+		"perfOK":                perfOK,
+		"dailyOK":               dailyOK,
+		"monthlyOK":             monthlyOK,
+		"yearlyOK":              yearlyOK,
+		"ytdOK":                 ytdOK,
+		"staleSince":            staleSince,
+		"energyYield":           energyTodayUnit.FromKWh(energyToday),
+		"energyYieldUnit":       energyTodayUnit,
 		"powerAvg":              par.GetFloat64("data", "0", "powerAvg"),
 		"powerAvgPct":           powerPct,
 		"powerAvgSpinDuration":  spinDuration,
-		"windAvg":               par.GetFloat64("data", "0", "windAvg"),
+		"windAvg":               u.WindSpeed(par.GetFloat64("data", "0", "windAvg")),
+		"windUnit":              u.Wind,
+		"energyPref":            u.EnergyPref(),
 		"windAvgArr":            windAvgArr,
 		"windMaxArr":            windMaxArr,
 		"energyYieldArr":        energyYieldArr,
+		"dailyUnit":             dailyUnit,
 		"dayArr":                dayArr,
+		"dailyFrom":             yesterday.AddDate(0, 0, -29).Format(time.DateOnly),
+		"dailyTo":               yesterday.Format(time.DateOnly),
 		"availArr":              availArr,
 		"lowWindArr":            lowWindArr,
-		"lastUpdate":            time.Now().Add(-time.Second * time.Duration(age)).Format(time.UnixDate),
+		"lastUpdate":            l.Date(time.Now().Add(-time.Second*time.Duration(age)), time.UnixDate),
 		"lastUpdateAge":         int(age),
 		"monthlyLabels":         monthlyLabelsArr,
 		"monthlyYield":          monthlyYieldArr,
+		"monthlyUnit":           monthlyUnit,
 		"monthlyIsCurrent":      monthlyIsCurrentArr,
 		"monthlyCapacityFactor": monthlyCapacityFactorArr,
 		"monthlyYoyChange":      monthlyYoyChangeArr,
+		"reportYear":            lastMonth.Year(),
+		"reportMonth":           int(lastMonth.Month()),
 		"yearlyLabels":          yearlyLabelsArr,
 		"yearlyYield":           yearlyYieldArr,
+		"yearlyUnit":            yearlyUnit,
 		"yearlyCapacityFactor":  yearlyCapacityFactorArr,
 		"yearlyYoyChange":       yearlyYoyChangeArr,
-		"ytdTotal":              ytdTotal,
+		"ytdTotal":              ytdUnit.FromKWh(ytdTotal * 1e3),
+		"ytdUnit":               ytdUnit,
 		"ytdYoyChange":          ytdYoyChange,
+		"status":                status,
+		"events":                events,
 		"version":               os.Getenv("FASTLY_SERVICE_VERSION"),
-	}, w)
+		"baseURL":               "https://" + r.Host,
+		"turbine":               TID,
+		"prefQuery":             prefQuery(r).Encode(),
+		"prefLink":              prefLink(r),
+	}.Update(l.templateContext()), w)
 	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Println(err)
+		errorPage(ctx, w, r, fsthttp.StatusInternalServerError, err)
 		return
 	}
 	// store, err := kvstore.Open(kvStoreName)
@@ -319,7 +421,7 @@ func index(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
 	// 	return
 	// }
 
-	// data, err := store.Lookup("202504")
+	// data, err := kvLookup(ctx, store, "202504")
 	// if err != nil {
 	// 	w.WriteHeader(fsthttp.StatusInternalServerError)
 	// 	fmt.Fprintln(w, err)
@@ -338,55 +440,65 @@ func index(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
 	// fmt.Fprint(w, s)
 }
 
+// spinDurationFor returns the turbine icon spin duration: 10s at 0% power,
+// 0.5s at 100% power (linear interpolation)
+func spinDurationFor(powerPct float64) float64 {
+	spinDuration := 10.0 - (powerPct/100.0)*9.5
+	if spinDuration < 0.5 {
+		spinDuration = 0.5
+	}
+	return spinDuration
+}
+
 func last30(ctx context.Context) (string, error) {
 	store, err := kvstore.Open(kvStoreName)
 	if err != nil {
 		return "", err
 	}
-	currentTime := time.Now()
-	end := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), 0, 0, 0, 0, time.UTC)
-	start := end.Add(-30 * 24 * time.Hour)
-	end = end.Add(-time.Second)
-	prev := start.Add(-time.Second)
-	if entry, err := store.Lookup(end.Format("060102")); err == nil {
+	_, end := last30Range()
+	if entry, err := kvLookup(ctx, store, end.Format("060102")); err == nil {
 		return entry.String(), err
 	}
-	// Construct base URL
-	baseURL := fmt.Sprintf("https://%s/api/v1.0/Customer/Performance", backendURL)
+	return storeLast30(ctx, store, refreshTTL())
+}
 
-	// Create URL with query parameters
-	parsedURL, err := url.Parse(baseURL)
+// storeLast30 fetches the last 30 days and stores them in KV for ttl seconds,
+// dropping the previous day's copy. The lazy path and the refresh job both
+// use it, so every copy expires the same way.
+func storeLast30(ctx context.Context, store *kvstore.Store, ttl uint32) (string, error) {
+	start, end := last30Range()
+	data, err := fetchLast30(ctx, start, end)
 	if err != nil {
 		return "", err
 	}
-
-	// Add query parameters
-	query := url.Values{}
-	query.Add("From", fmt.Sprintf("%d", start.Unix()))
-	query.Add("To", fmt.Sprintf("%d", end.Unix()))
-
-	parsedURL.RawQuery = query.Encode()
-	req, err := fsthttp.NewRequest("GET", parsedURL.String(), nil)
-	if err != nil {
+	if err := store.InsertWithConfig(end.Format("060102"), strings.NewReader(data), &kvstore.InsertConfig{TTLSec: ttl}); err != nil {
 		return "", err
 	}
+	store.Delete(start.Add(-time.Second).Format("060102"))
+	return data, nil
+}
 
-	req.Header.Set("ApiKey", getKey())
-	req.Header.Set("TID", TID)
-	req.CacheOptions = fsthttp.CacheOptions{TTL: 10 * 60}
+// last30Range is the 30 days up to the end of yesterday. They are stored in
+// KV under yesterday's date.
+func last30Range() (time.Time, time.Time) {
+	currentTime := time.Now()
+	end := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), 0, 0, 0, 0, time.UTC)
+	return end.Add(-30 * 24 * time.Hour), end.Add(-time.Second)
+}
 
-	resp, err := req.Send(ctx, backendName)
+// fetchLast30 gets the last 30 days straight from the API.
+func fetchLast30(ctx context.Context, start, end time.Time) (string, error) {
+	resp, err := vensysGet(ctx, "Performance", rangeQuery(start, end), rangeRoute(start, end))
 	if err != nil {
 		return "", err
 	}
+	if resp.StatusCode > 299 {
+		return "", errors.New(fsthttp.StatusText(resp.StatusCode))
+	}
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", err
 	}
-	if store.Insert(end.Format("060102"), bytes.NewReader(data)) != nil {
-		return "", err
-	}
-	store.Delete(prev.Format("060102"))
 	return string(data), nil
 }
 
@@ -399,34 +511,10 @@ func getYear(ctx context.Context, year int) (string, error) {
 	end := time.Date(year, 12, 31, 23, 59, 59, 0, time.UTC)
 	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
 
-	if entry, err := store.Lookup(end.Format("2006")); err == nil {
+	if entry, err := kvLookup(ctx, store, end.Format("2006")); err == nil {
 		return entry.String(), err
 	}
-	// Construct base URL
-	baseURL := fmt.Sprintf("https://%s/api/v1.0/Customer/Performance", backendURL)
-
-	// Create URL with query parameters
-	parsedURL, err := url.Parse(baseURL)
-	if err != nil {
-		return "", err
-	}
-
-	// Add query parameters
-	query := url.Values{}
-	query.Add("From", fmt.Sprintf("%d", start.Unix()))
-	query.Add("To", fmt.Sprintf("%d", end.Unix()))
-
-	parsedURL.RawQuery = query.Encode()
-	req, err := fsthttp.NewRequest("GET", parsedURL.String(), nil)
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set("ApiKey", getKey())
-	req.Header.Set("TID", TID)
-	req.CacheOptions = fsthttp.CacheOptions{TTL: 10 * 60}
-
-	resp, err := req.Send(ctx, backendName)
+	resp, err := vensysGet(ctx, "Performance", rangeQuery(start, end), rangeRoute(start, end))
 	if err != nil {
 		return "", err
 	}
@@ -457,55 +545,44 @@ func getMonthlyData(ctx context.Context, year, month int) (float64, error) {
 
 	keyStr := fmt.Sprintf("monthly-%04d%02d", year, month)
 
-	// Check cache only for completed past months
-	if isCompletedPastMonth {
-		if entry, err := store.Lookup(keyStr); err == nil {
-			var p fastjson.Parser
-			v, err := p.Parse(entry.String())
-			if err != nil {
-				return 0, err
-			}
-			return v.GetFloat64("data", "0", "energyYield"), nil
+	// Check cache only for completed past months, the current month may
+	// have been pre-warmed by the refresh job under its own short lived key
+	if !isCompletedPastMonth {
+		keyStr = fmt.Sprintf("current-%04d%02d", year, month)
+	}
+	if entry, err := kvLookup(ctx, store, keyStr); err == nil {
+		var p fastjson.Parser
+		v, err := p.Parse(entry.String())
+		if err != nil {
+			return 0, err
 		}
+		return v.GetFloat64("data", "0", "energyYield"), nil
 	}
 
-	// Calculate month boundaries
-	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	end := start.AddDate(0, 1, 0).Add(-time.Second) // Last second of month
-
-	// Fetch data from API
-	baseURL := fmt.Sprintf("https://%s/api/v1.0/Customer/Performance", backendURL)
-	parsedURL, err := url.Parse(baseURL)
+	totalEnergyYieldMWh, err := fetchMonthlyData(ctx, year, month)
 	if err != nil {
 		return 0, err
 	}
 
-	query := url.Values{}
-	query.Add("From", fmt.Sprintf("%d", start.Unix()))
-	query.Add("To", fmt.Sprintf("%d", end.Unix()))
-	parsedURL.RawQuery = query.Encode()
-
-	req, err := fsthttp.NewRequest("GET", parsedURL.String(), nil)
-	if err != nil {
-		return 0, err
+	// Store in KV if completed past month and we have valid data
+	if isCompletedPastMonth && totalEnergyYieldMWh > 0 {
+		storedData := fmt.Sprintf(`{"data":[{"month":"%04d%02d","energyYield":%f}]}`, year, month, totalEnergyYieldMWh)
+		store.Insert(keyStr, bytes.NewReader([]byte(storedData)))
 	}
 
-	req.Header.Set("ApiKey", getKey())
-	req.Header.Set("TID", TID)
-	req.CacheOptions = fsthttp.CacheOptions{TTL: 10 * 60}
+	return totalEnergyYieldMWh, nil
+}
 
-	resp, err := req.Send(ctx, backendName)
-	if err != nil {
-		return 0, err
-	}
-	data, err := io.ReadAll(resp.Body)
+// fetchMonthlyData sums the daily energy yield for a month from the API, in MWh.
+func fetchMonthlyData(ctx context.Context, year, month int) (float64, error) {
+	data, err := fetchMonthDays(ctx, year, month)
 	if err != nil {
 		return 0, err
 	}
 
 	// Parse and sum energy yield
 	var p fastjson.Parser
-	v, err := p.Parse(string(data))
+	v, err := p.Parse(data)
 	if err != nil {
 		return 0, err
 	}
@@ -517,15 +594,28 @@ func getMonthlyData(ctx context.Context, year, month int) (float64, error) {
 	}
 
 	// Convert from kWh to MWh
-	totalEnergyYieldMWh := totalEnergyYield / 1000.0
+	return totalEnergyYield / 1000.0, nil
+}
 
-	// Store in KV if completed past month and we have valid data
-	if isCompletedPastMonth && totalEnergyYieldMWh > 0 {
-		storedData := fmt.Sprintf(`{"data":[{"month":"%04d%02d","energyYield":%f}]}`, year, month, totalEnergyYieldMWh)
-		store.Insert(keyStr, bytes.NewReader([]byte(storedData)))
-	}
+// fetchMonthDays gets the daily performance for a month from the API.
+func fetchMonthDays(ctx context.Context, year, month int) (string, error) {
+	// Calculate month boundaries
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0).Add(-time.Second) // Last second of month
 
-	return totalEnergyYieldMWh, nil
+	// Fetch data from API
+	resp, err := vensysGet(ctx, "Performance", rangeQuery(start, end), rangeRoute(start, end))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode > 299 {
+		return "", errors.New(fsthttp.StatusText(resp.StatusCode))
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
 }
 
 func getLast12Months(ctx context.Context) (string, error) {
@@ -639,7 +729,7 @@ func getYearlyData(ctx context.Context, year int) (float64, error) {
 
 	// Check cache if not current year
 	if !isCurrentYear {
-		if entry, err := store.Lookup(keyStr); err == nil {
+		if entry, err := kvLookup(ctx, store, keyStr); err == nil {
 			var p fastjson.Parser
 			v, err := p.Parse(entry.String())
 			if err != nil {
@@ -748,6 +838,15 @@ func getYearToDateTotal(ctx context.Context) (float64, error) {
 	currentYear := now.Year()
 	currentMonth := int(now.Month())
 
+	// Use the total from the refresh job if it is still fresh
+	if store, err := kvstore.Open(kvStoreName); err == nil {
+		if entry, err := kvLookup(ctx, store, fmt.Sprintf("ytd-%04d", currentYear)); err == nil {
+			if ytd, err := strconv.ParseFloat(entry.String(), 64); err == nil {
+				return ytd, nil
+			}
+		}
+	}
+
 	var ytdTotal float64
 	for month := 1; month <= currentMonth; month++ {
 		monthlyYield, err := getMonthlyData(ctx, currentYear, month)
@@ -789,15 +888,13 @@ func history(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request)
 
 	store, err := kvstore.Open(kvStoreName)
 	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Fprintln(w, err)
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
 		return
 	}
 
-	data, err := store.Lookup("2025" + fmt.Sprintf("%02d", im))
+	data, err := kvLookup(ctx, store, "2025"+fmt.Sprintf("%02d", im))
 	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Fprintln(w, err)
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
 		return
 	}
 	// w.Header().Reset(resp.Header.Clone())
@@ -807,19 +904,21 @@ func history(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request)
 }
 
 func getLatestPerf(ctx context.Context) (string, uint32, error) {
-	var p string
-	var a uint32
-	baseURL := fmt.Sprintf("https://%s/api/v1.0/Customer/Performance", backendURL)
-
-	req, err := fsthttp.NewRequest("GET", baseURL, nil)
-	if err != nil {
-		return p, a, err
+	// Use the snapshot from the refresh job if it is still fresh
+	if store, err := kvstore.Open(kvStoreName); err == nil {
+		if entry, err := kvLookup(ctx, store, liveKey); err == nil {
+			fetched, _ := strconv.ParseInt(string(entry.Meta()), 10, 64)
+			return entry.String(), uint32(time.Since(time.Unix(fetched, 0)).Seconds()), nil
+		}
 	}
+	return fetchLatestPerf(ctx)
+}
 
-	req.Header.Set("ApiKey", getKey())
-	req.Header.Set("TID", TID)
-	req.CacheOptions = fsthttp.CacheOptions{TTL: 10 * 60}
-	resp, err := req.Send(ctx, backendName)
+// fetchLatestPerf gets today's performance straight from the API.
+func fetchLatestPerf(ctx context.Context) (string, uint32, error) {
+	var p string
+	var a uint32
+	resp, err := vensysGet(ctx, "Performance", nil, liveRoute())
 	if err != nil {
 		return p, a, err
 	}
@@ -827,7 +926,6 @@ func getLatestPerf(ctx context.Context) (string, uint32, error) {
 		return p, a, errors.New(fsthttp.StatusText(resp.StatusCode))
 	}
 	a, _ = resp.Age()
-	fmt.Println("Age", a)
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return p, a, err
@@ -838,33 +936,8 @@ func getLatestPerf(ctx context.Context) (string, uint32, error) {
 func getLatestMean(ctx context.Context) (string, uint32, error) {
 	var p string
 	var a uint32
-	baseURL := fmt.Sprintf("https://%s/api/v1.0/Customer/MeanData", backendURL)
-
-	// Create URL with query parameters
-	parsedURL, err := url.Parse(baseURL)
-	if err != nil {
-		fmt.Printf("Error parsing URL: %v\n", err)
-		return p, a, err
-	}
-
-	// Add query parameters
-	query := url.Values{}
-	now := time.Now().Round(time.Minute * 10)
-	query.Add("From", fmt.Sprintf("%d", now.Add(-time.Minute*70).Unix()))
-	query.Add("To", fmt.Sprintf("%d", now.Add(-time.Hour).Unix()))
-	// query.Add("Fields", *fields)
-	parsedURL.RawQuery = query.Encode()
-
-	// Create a new request
-	req, err := fsthttp.NewRequest("GET", parsedURL.String(), nil)
-	if err != nil {
-		return p, a, err
-	}
-
-	req.Header.Set("ApiKey", getKey())
-	req.Header.Set("TID", TID)
-	req.CacheOptions = fsthttp.CacheOptions{TTL: 10 * 60}
-	resp, err := req.Send(ctx, backendName)
+	slot := latestMeanSlot()
+	resp, err := vensysGet(ctx, "MeanData", rangeQuery(slot.Add(-time.Minute*10), slot), liveRoute())
 	if err != nil {
 		return p, a, err
 	}
@@ -880,6 +953,12 @@ func getLatestMean(ctx context.Context) (string, uint32, error) {
 	return string(b), a, nil
 }
 
+// latestMeanSlot is the end of the newest 10 minute MeanData slot, which the
+// API publishes with about an hour of delay.
+func latestMeanSlot() time.Time {
+	return time.Now().Round(time.Minute * 10).Add(-time.Hour)
+}
+
 func favicon(_ context.Context, w fsthttp.ResponseWriter, _ *fsthttp.Request) {
 	w.Header().Set("Content-Type", "image/x-icon")
 	w.Header().Set("Cache-Control", "public, max-age=86400")
@@ -887,70 +966,121 @@ func favicon(_ context.Context, w fsthttp.ResponseWriter, _ *fsthttp.Request) {
 }
 
 func exportMonthly(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
-	format := r.URL.Query().Get("format")
+	format, err := exportFormat(w, r)
+	if err != nil {
+		w.WriteHeader(fsthttp.StatusNotAcceptable)
+		fmt.Fprintf(w, "%v\n", err)
+		return
+	}
 
 	monthlyData, err := getLast12Months(ctx)
 	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Fprintf(w, "Error fetching monthly data: %v\n", err)
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error fetching monthly data", err)
 		return
 	}
 
 	monthly, err := fastjson.Parse(monthlyData)
 	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Fprintf(w, "Error parsing data: %v\n", err)
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error parsing data", err)
 		return
 	}
 
-	if format == "csv" {
-		w.Header().Set("Content-Type", "text/csv")
-		w.Header().Set("Content-Disposition", "attachment; filename=monthly_production.csv")
-		w.Header().Set("Cache-Control", "public, max-age=600")
+	// Parquet keeps the shared schema's kWh
+	var energy energyUnit
+	if format != "parquet" {
+		energy = convertAggregate(w, monthly, getUnitPrefs(r), 1e3)
+	}
+	// Twelve months and the year before them for the YoY change
+	now := time.Now()
+	setSurrogateKeys(w, monthKeys(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(-1, -11, 0), now))
+
+	// Pollers get a 304 until the aggregates change
+	etag := newETag(exportRepr(r, format)...)
+	io.WriteString(etag, monthlyData)
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	if notModified(w, r, etagValue(etag), aggregateModified(monthly, "months", "Jan 2006")) {
+		return
+	}
+
+	switch format {
+	case "csv":
+		l := negotiateLocale(r)
+		csvHeaders(w, l, "monthly_production.csv")
 
 		// Write CSV header
-		fmt.Fprintln(w, "Month,Energy (MWh),Capacity Factor (%),YoY Change (%)")
+		writeCSVRow(w, l, l.T("Month"), l.T("Energy (%s)", energy.Name), l.T("Capacity Factor (%)"), l.T("YoY Change (%)"))
 
 		// Write data rows
 		for i, m := range monthly.GetArray("months") {
-			month := string(m.GetStringBytes())
+			month := l.MonthLabel(string(m.GetStringBytes()))
 			yield := monthly.GetArray("energyYield")[i].GetFloat64()
 			cf := monthly.GetArray("capacityFactor")[i].GetFloat64()
 			yoy := monthly.GetArray("yoyChange")[i].GetFloat64()
-			fmt.Fprintf(w, "%s,%.2f,%.2f,%.2f\n", month, yield, cf, yoy)
+			writeCSVRow(w, l, month, l.Num(yield, energy.Decimals+2), l.Num(cf, 2), l.Num(yoy, 2))
+		}
+	case "xlsx":
+		w.Header().Set("Content-Type", xlsxContentType)
+		w.Header().Set("Content-Disposition", "attachment; filename=monthly_production.xlsx")
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		if err := writeAggregateXLSX(w, monthly, "months", "Month", "Energy ("+energy.Name+")", "Last 12 months"); err != nil {
+			logFor(ctx).Error("writing export", err, "format", format)
 		}
-	} else {
+	case "parquet":
+		w.Header().Set("Content-Type", parquetContentType)
+		w.Header().Set("Content-Disposition", "attachment; filename=monthly_production.parquet")
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		if err := writeAggregateParquet(ctx, w, monthly, "months", "Jan 2006", 1e3); err != nil {
+			logFor(ctx).Error("writing export", err, "format", format)
+		}
+	default:
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Content-Disposition", "attachment; filename=monthly_production.json")
 		w.Header().Set("Cache-Control", "public, max-age=600")
-		fmt.Fprint(w, monthlyData)
+		w.Write(monthly.MarshalTo(nil))
 	}
 }
 
 func exportYearly(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
-	format := r.URL.Query().Get("format")
+	format, err := exportFormat(w, r)
+	if err != nil {
+		w.WriteHeader(fsthttp.StatusNotAcceptable)
+		fmt.Fprintf(w, "%v\n", err)
+		return
+	}
 
 	yearlyData, err := getYearsSince2020(ctx)
 	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Fprintf(w, "Error fetching yearly data: %v\n", err)
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error fetching yearly data", err)
 		return
 	}
 
 	yearly, err := fastjson.Parse(yearlyData)
 	if err != nil {
-		w.WriteHeader(fsthttp.StatusInternalServerError)
-		fmt.Fprintf(w, "Error parsing data: %v\n", err)
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error parsing data", err)
 		return
 	}
 
-	if format == "csv" {
-		w.Header().Set("Content-Type", "text/csv")
-		w.Header().Set("Content-Disposition", "attachment; filename=yearly_production.csv")
-		w.Header().Set("Cache-Control", "public, max-age=600")
+	// Parquet keeps the shared schema's kWh
+	var energy energyUnit
+	if format != "parquet" {
+		energy = convertAggregate(w, yearly, getUnitPrefs(r), 1e6)
+	}
+	setSurrogateKeys(w, yearKeys(2021, time.Now().Year()))
+
+	etag := newETag(exportRepr(r, format)...)
+	io.WriteString(etag, yearlyData)
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	if notModified(w, r, etagValue(etag), aggregateModified(yearly, "years", "2006")) {
+		return
+	}
+
+	switch format {
+	case "csv":
+		l := negotiateLocale(r)
+		csvHeaders(w, l, "yearly_production.csv")
 
 		// Write CSV header
-		fmt.Fprintln(w, "Year,Energy (GWh),Capacity Factor (%),YoY Change (%)")
+		writeCSVRow(w, l, l.T("Year"), l.T("Energy (%s)", energy.Name), l.T("Capacity Factor (%)"), l.T("YoY Change (%)"))
 
 		// Write data rows
 		for i, y := range yearly.GetArray("years") {
@@ -958,12 +1088,26 @@ func exportYearly(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Requ
 			yield := yearly.GetArray("energyYield")[i].GetFloat64()
 			cf := yearly.GetArray("capacityFactor")[i].GetFloat64()
 			yoy := yearly.GetArray("yoyChange")[i].GetFloat64()
-			fmt.Fprintf(w, "%s,%.2f,%.2f,%.2f\n", year, yield, cf, yoy)
+			writeCSVRow(w, l, year, l.Num(yield, energy.Decimals+2), l.Num(cf, 2), l.Num(yoy, 2))
+		}
+	case "xlsx":
+		w.Header().Set("Content-Type", xlsxContentType)
+		w.Header().Set("Content-Disposition", "attachment; filename=yearly_production.xlsx")
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		if err := writeAggregateXLSX(w, yearly, "years", "Year", "Energy ("+energy.Name+")", "Since 2022"); err != nil {
+			logFor(ctx).Error("writing export", err, "format", format)
+		}
+	case "parquet":
+		w.Header().Set("Content-Type", parquetContentType)
+		w.Header().Set("Content-Disposition", "attachment; filename=yearly_production.parquet")
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		if err := writeAggregateParquet(ctx, w, yearly, "years", "2006", 1e6); err != nil {
+			logFor(ctx).Error("writing export", err, "format", format)
 		}
-	} else {
+	default:
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Content-Disposition", "attachment; filename=yearly_production.json")
 		w.Header().Set("Cache-Control", "public, max-age=600")
-		fmt.Fprint(w, yearlyData)
+		w.Write(yearly.MarshalTo(nil))
 	}
 }
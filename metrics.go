@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+	"github.com/valyala/fastjson"
+)
+
+// latencyKey holds the duration of the refresh job's last call to the API.
+const latencyKey = "upstream-latency"
+
+// metrics exposes the current turbine state in OpenMetrics text format.
+func metrics(ctx context.Context, w fsthttp.ResponseWriter, _ *fsthttp.Request) {
+	latestPerf, age, err := getLatestPerf(ctx)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error fetching performance", err)
+		return
+	}
+	par, err := fastjson.Parse(latestPerf)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error parsing data", err)
+		return
+	}
+	ytdTotal, err := getYearToDateTotal(ctx)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error fetching year to date", err)
+		return
+	}
+	days, err := getLast30Days(ctx)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error fetching last 30 days", err)
+		return
+	}
+	availability := 0.0
+	if len(days) > 0 {
+		for _, day := range days {
+			availability += day.Avail
+		}
+		availability /= float64(len(days))
+	}
+
+	powerAvg := par.GetFloat64("data", "0", "powerAvg")
+
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-store")
+	writeGauge(w, "windash_power_kilowatts", "kilowatts", "Average power output of the current interval.", powerAvg)
+	writeGauge(w, "windash_power_percent", "percent", "Power output as a percentage of nominal power.", powerAvg/powerNominal*100)
+	writeGauge(w, "windash_wind_speed_meters_per_second", "meters_per_second", "Average wind speed of the current interval.", par.GetFloat64("data", "0", "windAvg"))
+	writeGauge(w, "windash_energy_today_kilowatthours", "kilowatthours", "Energy produced today.", par.GetFloat64("data", "0", "energyYield"))
+	writeGauge(w, "windash_energy_ytd_megawatthours", "megawatthours", "Energy produced this year.", ytdTotal)
+	writeGauge(w, "windash_availability_30d_percent", "percent", "Mean availability over the last 30 days.", availability)
+	if latency, ok := getUpstreamLatency(ctx); ok {
+		writeGauge(w, "windash_upstream_latency_seconds", "seconds", "Duration of the last call to the turbine API.", latency.Seconds())
+	}
+	writeGauge(w, "windash_cache_age_seconds", "seconds", "Age of the cached performance data.", float64(age))
+	fmt.Fprint(w, "# EOF\n")
+}
+
+func writeGauge(w io.Writer, name, unit, help string, value float64) {
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "# UNIT %s %s\n", name, unit)
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "%s{turbine=\"%s\"} %s\n", name, TID, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// getUpstreamLatency returns how long the last call to the API took: the one
+// made by this request if there was one, otherwise the refresh job's.
+func getUpstreamLatency(ctx context.Context) (time.Duration, bool) {
+	if upstreamLatency > 0 {
+		return upstreamLatency, true
+	}
+	store, err := kvstore.Open(kvStoreName)
+	if err != nil {
+		return 0, false
+	}
+	entry, err := kvLookup(ctx, store, latencyKey)
+	if err != nil {
+		return 0, false
+	}
+	secs, err := strconv.ParseFloat(entry.String(), 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
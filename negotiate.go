@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+)
+
+// compressibleTypes are compressed on the way out. PNGs, PDFs and XLSX files
+// are compressed already, and the live stream must not be held back by a
+// compressor.
+var compressibleTypes = map[string]bool{
+	"text/html":                    true,
+	"text/csv":                     true,
+	"text/plain":                   true,
+	"application/json":             true,
+	"application/atom+xml":         true,
+	"application/openmetrics-text": true,
+	"image/svg+xml":                true,
+	"image/x-icon":                 true,
+	parquetContentType:             true, // written uncompressed
+}
+
+// compressWriter asks the edge to compress compressible responses. Fastly
+// then encodes them with brotli or gzip, whichever the client's
+// Accept-Encoding prefers, and caches each encoding separately.
+type compressWriter struct {
+	fsthttp.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		contentType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+		if code != fsthttp.StatusNoContent && code != fsthttp.StatusNotModified &&
+			h.Get("Content-Encoding") == "" && compressibleTypes[strings.TrimSpace(contentType)] {
+			h.Set("X-Compress-Hint", "on")
+			h.Add("Vary", "Accept-Encoding")
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(fsthttp.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// exportMediaTypes are the formats an export can be asked for with Accept.
+var exportMediaTypes = []struct {
+	Type   string
+	Format string
+}{
+	{"application/json", "json"},
+	{"text/csv", "csv"},
+	{xlsxContentType, "xlsx"},
+	{parquetContentType, "parquet"},
+}
+
+var errNotAcceptable = errors.New("none of the accepted types can be exported, use application/json, text/csv, " + xlsxContentType + " or " + parquetContentType)
+
+// exportFormat returns the format query parameter or else the format the
+// Accept header prefers. Each format gets the q-value of the most specific
+// range that matches it, so "application/json;q=0, */*" rules JSON out, and
+// ties go to the range listed first. An Accept header that allows nothing we
+// can export is an error; none at all, or only wildcards, means JSON.
+func exportFormat(w fsthttp.ResponseWriter, r *fsthttp.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format, nil
+	}
+	w.Header().Add("Vary", "Accept")
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return "", nil
+	}
+
+	type mediaRange struct {
+		Type string
+		Q    float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		mr := mediaRange{Type: strings.ToLower(strings.TrimSpace(mediaType)), Q: 1}
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				mr.Q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		ranges = append(ranges, mr)
+	}
+
+	format, best, bestPos := "", 0.0, 0
+	for _, t := range exportMediaTypes {
+		major, _, _ := strings.Cut(t.Type, "/")
+		q, pos, specificity := 0.0, 0, 0
+		for i, mr := range ranges {
+			s := 0
+			switch mr.Type {
+			case t.Type:
+				s = 3
+			case major + "/*":
+				s = 2
+			case "*/*":
+				s = 1
+			}
+			if s > specificity {
+				q, pos, specificity = mr.Q, i, s
+			}
+		}
+		if q > best || (q == best && q > 0 && pos < bestPos) {
+			format, best, bestPos = t.Format, q, pos
+		}
+	}
+	if best == 0 {
+		return "", errNotAcceptable
+	}
+	return format, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/fsttest"
+)
+
+func TestExportFormat(t *testing.T) {
+	tests := []struct {
+		query, accept string
+		want          string
+		wantErr       bool
+	}{
+		{"", "", "", false},
+		{"", "application/json", "json", false},
+		{"", "text/csv", "csv", false},
+		{"", "TEXT/CSV; charset=utf-8", "csv", false},
+		{"", xlsxContentType, "xlsx", false},
+		{"", parquetContentType, "parquet", false},
+
+		// q-values, then the order of the header
+		{"", "application/json;q=0.5, text/csv", "csv", false},
+		{"", "text/csv;q=0.8, " + xlsxContentType + ";q=0.9", "xlsx", false},
+		{"", "text/csv, application/json", "csv", false},
+		{"", "application/json, text/csv", "json", false},
+		{"", "text/csv;q=0.5, application/json;q=0.5", "csv", false},
+		{"", "text/csv;level=1;q=0.4, application/json;q=0.3", "csv", false},
+
+		// q=0 rules a type out, even when a wildcard would allow it
+		{"", "text/csv;q=0, application/json", "json", false},
+		{"", "application/json;q=0, */*;q=0.5", "csv", false},
+		{"", "application/json;q=0, text/csv;q=0, */*", "xlsx", false},
+		{"", "text/*;q=0, */*;q=0.1", "json", false},
+		{"", "text/csv;q=0", "", true},
+
+		// Wildcards
+		{"", "*/*", "json", false},
+		{"", "text/*", "csv", false},
+		{"", "application/*", "json", false},
+		{"", "text/html, */*;q=0.1", "json", false},
+		{"", "text/csv;q=0.2, application/*;q=0.5", "json", false},
+		{"", "text/*;q=0.9, application/json;q=0.5", "csv", false},
+
+		// Nothing exportable
+		{"", "text/html", "", true},
+		{"", "image/*, text/html;q=0.9", "", true},
+		{"", "*/*;q=0", "", true},
+
+		// The format parameter wins
+		{"?format=xlsx", "text/csv", "xlsx", false},
+		{"?format=csv", "text/html", "csv", false},
+	}
+	for _, tt := range tests {
+		r, err := fsthttp.NewRequest("GET", "https://example.com/export/monthly"+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := fsttest.NewRecorder()
+		got, err := exportFormat(w, r)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("exportFormat(%q, Accept %q) = %q, %v, want %q, error %v", tt.query, tt.accept, got, err, tt.want, tt.wantErr)
+		}
+		if vary := w.Header().Get("Vary"); (vary == "Accept") == (tt.query != "") {
+			t.Errorf("exportFormat(%q, Accept %q) Vary = %q", tt.query, tt.accept, vary)
+		}
+	}
+}
+
+func TestExportNotAcceptable(t *testing.T) {
+	exports := map[string]func(context.Context, fsthttp.ResponseWriter, *fsthttp.Request){
+		"/export/monthly": exportMonthly,
+		"/export/yearly":  exportYearly,
+		"/export/daily":   exportDaily,
+	}
+	for path, export := range exports {
+		r, err := fsthttp.NewRequest("GET", "https://example.com"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Accept", "text/html, image/*;q=0.8")
+		w := fsttest.NewRecorder()
+		export(context.Background(), w, r)
+		if w.Code != fsthttp.StatusNotAcceptable {
+			t.Errorf("%s status = %d, want %d", path, w.Code, fsthttp.StatusNotAcceptable)
+		}
+		if w.Body.String() != errNotAcceptable.Error()+"\n" {
+			t.Errorf("%s body = %q", path, w.Body.String())
+		}
+		if got := w.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("%s Vary = %q, want Accept", path, got)
+		}
+	}
+}
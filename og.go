@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/valyala/fastjson"
+)
+
+// Open Graph images are 1200x630, the size Slack, Teams and most social
+// sites crop to.
+const (
+	ogWidth  = 1200
+	ogHeight = 630
+)
+
+var (
+	ogBackground = color.RGBA{0xf3, 0xf4, 0xf6, 0xff}
+	ogText       = color.RGBA{0x1f, 0x29, 0x37, 0xff}
+	ogMuted      = color.RGBA{0x6b, 0x72, 0x80, 0xff}
+	ogBar        = color.RGBA{0xe5, 0xe7, 0xeb, 0xff}
+	ogBlue       = color.RGBA{0x25, 0x63, 0xeb, 0xff}
+	ogGreen      = color.RGBA{0x05, 0x96, 0x69, 0xff}
+	ogRed        = color.RGBA{0xdc, 0x26, 0x26, 0xff}
+)
+
+// ogSummary is what the preview image shows.
+type ogSummary struct {
+	PowerAvg     float64 // kW
+	YtdTotal     float64 // MWh
+	YtdYoyChange float64 // %
+	Updated      time.Time
+}
+
+// getYtdYoyChange compares the year to date total with the same period of
+// last year. It returns 0 if there is nothing to compare with.
+func getYtdYoyChange(ctx context.Context, ytdTotal float64) float64 {
+	now := time.Now()
+	prevYearYTD, err := getYearToDateTotalForYear(ctx, now.Year()-1, int(now.Month()))
+	if err != nil || prevYearYTD <= 0 {
+		return 0
+	}
+	return ((ytdTotal - prevYearYTD) / prevYearYTD) * 100
+}
+
+func getOgSummary(ctx context.Context) (ogSummary, error) {
+	latestPerf, age, err := getLatestPerf(ctx)
+	if err != nil {
+		return ogSummary{}, err
+	}
+	par, err := fastjson.Parse(latestPerf)
+	if err != nil {
+		return ogSummary{}, err
+	}
+	ytdTotal, err := getYearToDateTotal(ctx)
+	if err != nil {
+		return ogSummary{}, err
+	}
+	return ogSummary{
+		PowerAvg:     par.GetFloat64("data", "0", "powerAvg"),
+		YtdTotal:     ytdTotal,
+		YtdYoyChange: getYtdYoyChange(ctx, ytdTotal),
+		Updated:      time.Now().Add(-time.Second * time.Duration(age)),
+	}, nil
+}
+
+// renderOgImage draws the summary as a PNG in the units of u and the language
+// of l. A paletted image keeps the file small and quick to encode.
+func renderOgImage(s ogSummary, u unitPrefs, l locale) ([]byte, error) {
+	img := image.NewPaletted(image.Rect(0, 0, ogWidth, ogHeight), color.Palette{
+		ogBackground, ogText, ogMuted, ogBar, ogBlue, ogGreen, ogRed,
+	})
+	const left = 80
+
+	fillRect(img, 0, 0, ogWidth, 16, uint8(img.Palette.Index(ogBlue)))
+	drawText(img, left, 70, 6, ogText, l.T("Wind Turbine %s", TID))
+
+	drawText(img, left, 190, 4, ogMuted, l.T("Current Power"))
+	drawText(img, left, 240, 12, ogText, l.Num(s.PowerAvg, 0)+" kW")
+
+	const right = 640
+	ytd := u.EnergyUnit(s.YtdTotal * 1e3)
+	drawText(img, right, 190, 4, ogMuted, l.T("Year to Date %d", time.Now().Year()))
+	drawText(img, right, 240, 8, ogText, l.Num(ytd.FromKWh(s.YtdTotal*1e3), ytd.Decimals)+" "+ytd.Name)
+	if s.YtdYoyChange != 0 {
+		c, up := ogGreen, true
+		if s.YtdYoyChange < 0 {
+			c, up = ogRed, false
+		}
+		drawArrow(img, right, 330, 30, uint8(img.Palette.Index(c)), up)
+		yoy := l.Num(s.YtdYoyChange, 1)
+		if up {
+			yoy = "+" + yoy
+		}
+		drawText(img, right+45, 330, 5, c, l.T("%s%% vs %d", yoy, time.Now().Year()-1))
+	}
+
+	// Power as a share of nominal power
+	pct := min(max(s.PowerAvg/powerNominal, 0), 1)
+	fillRect(img, left, 430, ogWidth-2*left, 32, uint8(img.Palette.Index(ogBar)))
+	fillRect(img, left, 430, int(pct*float64(ogWidth-2*left)), 32, uint8(img.Palette.Index(ogBlue)))
+	drawText(img, left, 480, 4, ogMuted, l.T("%s%% of %s kW capacity", l.Num(pct*100, 0), l.Num(powerNominal, 0)))
+
+	updated := l.T("Updated %s", l.Date(s.Updated.UTC(), "2 Jan 2006 15:04")+" UTC")
+	drawText(img, ogWidth-left-textWidth(updated, 3), 560, 3, ogMuted, updated)
+
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawArrow draws a filled triangle size pixels high pointing up or down.
+func drawArrow(img *image.Paletted, x, y, size int, idx uint8, up bool) {
+	for row := 0; row < size; row++ {
+		half := row / 2
+		if !up {
+			half = (size - 1 - row) / 2
+		}
+		fillRect(img, x+size/2-half, y+row, 2*half+1, 1, idx)
+	}
+}
+
+// ogImage serves the Open Graph preview image. It is cached for as long as
+// the dashboard itself, so link previews match the page, and takes the same
+// unit and language preferences.
+func ogImage(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	summary, err := getOgSummary(ctx)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	data, err := renderOgImage(summary, getUnitPrefs(r), negotiateLocale(r))
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	w.Header().Add("Vary", "Accept-Language")
+	w.Write(data)
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+const parquetContentType = "application/vnd.apache.parquet"
+
+// Parquet physical and converted types used by the exports.
+const (
+	parquetInt32     = 1
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetNoConverted = -1
+	parquetUTF8        = 0
+	parquetDate        = 6
+)
+
+// parquetColumn describes a required, flat column.
+type parquetColumn struct {
+	Name      string
+	Type      int32
+	Converted int32
+}
+
+// exportColumns is the schema shared by all Parquet exports.
+var exportColumns = []parquetColumn{
+	{"date", parquetInt32, parquetDate},
+	{"turbine", parquetByteArray, parquetUTF8},
+	{"energy_kwh", parquetDouble, parquetNoConverted},
+	{"wind_avg", parquetDouble, parquetNoConverted},
+	{"wind_max", parquetDouble, parquetNoConverted},
+	{"availability", parquetDouble, parquetNoConverted},
+	{"low_wind_seconds", parquetDouble, parquetNoConverted},
+	{"capacity_factor", parquetDouble, parquetNoConverted},
+}
+
+type parquetChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int64
+}
+
+// parquetWriter writes an uncompressed, PLAIN encoded Parquet file. Rows are
+// buffered per column until Flush writes them out as a row group, so callers
+// streaming long ranges can flush regularly to bound memory. Only the parts
+// of the format needed here are implemented, without reflection or thrift
+// code generation, so it builds under TinyGo.
+type parquetWriter struct {
+	w      io.Writer
+	off    int64
+	cols   []parquetColumn
+	bufs   [][]byte
+	rows   int64
+	groups []parquetRowGroup
+}
+
+func newParquetWriter(w io.Writer, cols []parquetColumn) (*parquetWriter, error) {
+	p := &parquetWriter{w: w, cols: cols, bufs: make([][]byte, len(cols))}
+	return p, p.write([]byte("PAR1"))
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.off += int64(n)
+	return err
+}
+
+// Row buffers one row. Values must be int32, float64 or string to match the
+// column types.
+func (p *parquetWriter) Row(values ...any) error {
+	if len(values) != len(p.cols) {
+		return fmt.Errorf("parquet: got %d values for %d columns", len(values), len(p.cols))
+	}
+	for i, v := range values {
+		b := p.bufs[i]
+		switch v := v.(type) {
+		case int32:
+			b = binary.LittleEndian.AppendUint32(b, uint32(v))
+		case float64:
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+		case string:
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(v)))
+			b = append(b, v...)
+		default:
+			return fmt.Errorf("parquet: unsupported value %T for column %s", v, p.cols[i].Name)
+		}
+		p.bufs[i] = b
+	}
+	p.rows++
+	return nil
+}
+
+// Flush writes the buffered rows as a row group.
+func (p *parquetWriter) Flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+	g := parquetRowGroup{rows: p.rows}
+	for i, data := range p.bufs {
+		var t thriftWriter
+		t.i32(1, 0) // type: DATA_PAGE
+		t.i32(2, int32(len(data)))
+		t.i32(3, int32(len(data)))
+		t.beginStruct(5) // data_page_header
+		t.i32(1, int32(p.rows))
+		t.i32(2, 0) // encoding: PLAIN
+		t.i32(3, 3) // definition_level_encoding: RLE
+		t.i32(4, 3) // repetition_level_encoding: RLE
+		t.endStruct()
+		t.stop()
+
+		chunk := parquetChunk{offset: p.off, size: int64(len(t.b) + len(data)), values: p.rows}
+		if err := p.write(t.b); err != nil {
+			return err
+		}
+		if err := p.write(data); err != nil {
+			return err
+		}
+		g.chunks = append(g.chunks, chunk)
+		p.bufs[i] = data[:0]
+	}
+	p.groups = append(p.groups, g)
+	p.rows = 0
+	return nil
+}
+
+// Close flushes any remaining rows and writes the footer.
+func (p *parquetWriter) Close() error {
+	if err := p.Flush(); err != nil {
+		return err
+	}
+	var total int64
+	for _, g := range p.groups {
+		total += g.rows
+	}
+
+	var t thriftWriter
+	t.i32(1, 1) // version
+	t.beginList(2, thriftStruct, len(p.cols)+1)
+	t.beginElem()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(p.cols))) // num_children
+	t.endStruct()
+	for _, c := range p.cols {
+		t.beginElem()
+		t.i32(1, c.Type)
+		t.i32(3, 0) // repetition_type: REQUIRED
+		t.binary(4, c.Name)
+		if c.Converted != parquetNoConverted {
+			t.i32(6, c.Converted)
+		}
+		t.endStruct()
+	}
+	t.endList()
+	t.i64(3, total)
+	t.beginList(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		var size int64
+		t.beginElem()
+		t.beginList(1, thriftStruct, len(g.chunks))
+		for i, c := range g.chunks {
+			size += c.size
+			t.beginElem()
+			t.i64(2, c.offset) // file_offset
+			t.beginStruct(3)   // meta_data
+			t.i32(1, p.cols[i].Type)
+			t.beginList(2, thriftI32, 1)
+			t.elemI32(0) // PLAIN
+			t.endList()
+			t.beginList(3, thriftBinary, 1)
+			t.elemBinary(p.cols[i].Name)
+			t.endList()
+			t.i32(4, 0) // codec: UNCOMPRESSED
+			t.i64(5, c.values)
+			t.i64(6, c.size)
+			t.i64(7, c.size)
+			t.i64(9, c.offset) // data_page_offset
+			t.endStruct()
+			t.endStruct()
+		}
+		t.endList()
+		t.i64(2, size)
+		t.i64(3, g.rows)
+		t.endStruct()
+	}
+	t.endList()
+	t.binary(6, "windash")
+	t.stop()
+
+	if err := p.write(t.b); err != nil {
+		return err
+	}
+	if err := p.write(binary.LittleEndian.AppendUint32(nil, uint32(len(t.b)))); err != nil {
+		return err
+	}
+	return p.write([]byte("PAR1"))
+}
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes just enough of the Thrift compact protocol for the
+// Parquet page headers and footer. Structs inside a list are written between
+// beginElem and endStruct.
+type thriftWriter struct {
+	b     []byte
+	last  int16
+	stack []int16
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	t.b = binary.AppendUvarint(t.b, v)
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if d := id - t.last; d > 0 && d <= 15 {
+		t.b = append(t.b, byte(d)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.uvarint(uint64(uint16((id << 1) ^ (id >> 15))))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.elemI32(v)
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.elemBinary(s)
+}
+
+func (t *thriftWriter) elemI32(v int32) {
+	t.uvarint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+func (t *thriftWriter) elemBinary(s string) {
+	t.uvarint(uint64(len(s)))
+	t.b = append(t.b, s...)
+}
+
+func (t *thriftWriter) push() {
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) pop() {
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.push()
+}
+
+func (t *thriftWriter) beginElem() {
+	t.push()
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.pop()
+}
+
+func (t *thriftWriter) beginList(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|elem)
+	} else {
+		t.b = append(t.b, 0xf0|elem)
+		t.uvarint(uint64(n))
+	}
+	t.push()
+}
+
+func (t *thriftWriter) endList() {
+	t.pop()
+}
+
+func (t *thriftWriter) stop() {
+	t.b = append(t.b, 0)
+}
+
+// parquetDays converts a date to the Parquet DATE representation.
+func parquetDays(t time.Time) int32 {
+	return int32(t.Unix() / 86400)
+}
+
+// writeAggregateParquet writes the monthly or yearly aggregates, one row per
+// period. Energy and capacity factor come from the aggregates, the wind and
+// availability columns are rolled up from the daily data of each period.
+func writeAggregateParquet(ctx context.Context, w io.Writer, v *fastjson.Value, labelsKey, layout string, toKWh float64) error {
+	p, err := newParquetWriter(w, exportColumns)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	yields := v.GetArray("energyYield")
+	cfs := v.GetArray("capacityFactor")
+	for i, l := range v.GetArray(labelsKey) {
+		start, err := time.Parse(layout, string(l.GetStringBytes()))
+		if err != nil {
+			return err
+		}
+		end := start.AddDate(0, 1, -1)
+		if layout == "2006" {
+			end = start.AddDate(1, 0, -1)
+		}
+		// The current period only has data up to today
+		if end.After(today) {
+			end = today
+		}
+		r, err := rollupDays(ctx, start, end)
+		if err != nil {
+			return err
+		}
+		err = p.Row(parquetDays(start), TID, yields[i].GetFloat64()*toKWh, r.WindAvg, r.WindMax, r.Avail, r.LowWindTime, cfs[i].GetFloat64())
+		if err != nil {
+			return err
+		}
+	}
+	return p.Close()
+}
+
+// writeDailyParquet streams one row per day, writing a row group per month.
+func writeDailyParquet(ctx context.Context, w io.Writer, from, to time.Time) error {
+	p, err := newParquetWriter(w, exportColumns)
+	if err != nil {
+		return err
+	}
+	month := from.Month()
+	err = eachDay(ctx, from, to, func(d dailyRow) error {
+		if d.Date.Month() != month {
+			month = d.Date.Month()
+			if err := p.Flush(); err != nil {
+				return err
+			}
+		}
+		cf := d.EnergyYield / (powerNominal * 24) * 100
+		return p.Row(parquetDays(d.Date), TID, d.EnergyYield, d.WindAvg, d.WindMax, d.Avail, d.LowWindTime, cf)
+	})
+	if err != nil {
+		return err
+	}
+	return p.Close()
+}
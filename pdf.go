@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 in points.
+const (
+	pdfWidth  = 595
+	pdfHeight = 842
+)
+
+// pdfPage collects the drawing operators for a single page. Coordinates are
+// in points from the bottom left corner.
+type pdfPage struct {
+	ops    bytes.Buffer
+	annots []string
+}
+
+// Text draws s with its baseline starting at x, y.
+func (p *pdfPage) Text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.ops, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfString(s))
+}
+
+// Rect fills a rectangle with an RGB colour, components from 0 to 1. The
+// colour is set inside a saved graphics state, so text drawn afterwards stays
+// black.
+func (p *pdfPage) Rect(x, y, w, h, r, g, b float64) {
+	fmt.Fprintf(&p.ops, "q %.3f %.3f %.3f rg %.2f %.2f %.2f %.2f re f Q\n", r, g, b, x, y, w, h)
+}
+
+// Line strokes a thin grey line.
+func (p *pdfPage) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.ops, "q 0.8 0.8 0.8 RG 0.5 w %.2f %.2f m %.2f %.2f l S Q\n", x1, y1, x2, y2)
+}
+
+// Link makes the w by h area from x, y open uri when clicked.
+func (p *pdfPage) Link(x, y, w, h float64, uri string) {
+	p.annots = append(p.annots, fmt.Sprintf("<< /Type /Annot /Subtype /Link /Rect [%.2f %.2f %.2f %.2f] /Border [0 0 0] /A << /S /URI /URI (%s) >> >>", x, y, x+w, y+h, pdfString(uri)))
+}
+
+// pdfString escapes s for a PDF literal string, mapping it onto
+// WinAnsiEncoding. Characters outside Latin-1 become '?'.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r < 0x80:
+			b.WriteRune(r)
+		case r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// pdfDocument renders the pages as a PDF using the standard Helvetica fonts,
+// so nothing has to be embedded.
+func pdfDocument(pages []*pdfPage) []byte {
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// 1: catalog, 2: pages, 3-4: fonts, then a page and content pair per page
+	var kids []string
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+i*2))
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, p := range pages {
+		annots := ""
+		if len(p.annots) > 0 {
+			annots = " /Annots [" + strings.Join(p.annots, " ") + "]"
+		}
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R%s >>", pdfWidth, pdfHeight, 6+i*2, annots))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.ops.Len(), p.ops.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fastjson"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+	"github.com/fastly/compute-sdk-go/purge"
+)
+
+// Responses are kept at the edge for the edge TTL and purged by surrogate key
+// when their data changes, browsers still only keep them for 10 minutes.
+const (
+	defaultEdgeTTL = 24 * 60 * 60 // seconds
+	maxDayKeys     = 62
+)
+
+// dayKeys returns the key of every day from..to, or of the months for longer
+// ranges to keep the header short.
+func dayKeys(from, to time.Time) []string {
+	if to.Sub(from) > maxDayKeys*24*time.Hour {
+		return monthKeys(from, to)
+	}
+	keys := []string{turbineKey()}
+	for d := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC); !d.After(to); d = d.AddDate(0, 0, 1) {
+		keys = append(keys, turbineKey(d.Format(time.DateOnly)))
+	}
+	return keys
+}
+
+// yearKeys returns the key of every year from..to.
+func yearKeys(from, to int) []string {
+	keys := []string{turbineKey()}
+	for y := from; y <= to; y++ {
+		keys = append(keys, turbineKey(strconv.Itoa(y)))
+	}
+	return keys
+}
+
+// setSurrogateKeys tags a response with the keys of the data it was built
+// from and lets the edge keep it for the edge TTL.
+func setSurrogateKeys(w fsthttp.ResponseWriter, keys ...[]string) {
+	var all []string
+	for _, k := range keys {
+		for _, key := range k {
+			if !slices.Contains(all, key) {
+				all = append(all, key)
+			}
+		}
+	}
+	w.Header().Set("Surrogate-Key", strings.Join(all, " "))
+	w.Header().Set("Surrogate-Control", fmt.Sprintf("max-age=%d", int(getConfigFloat("edge-ttl-seconds", defaultEdgeTTL))))
+}
+
+// refreshPurge purges what the refresh job has just brought up to date: live
+// values and whatever covers today. The KV copies were rewritten by the other
+// steps, so only the edge is purged.
+func refreshPurge(ctx context.Context, _ *kvstore.Store, _ uint32) error {
+	now := time.Now().UTC()
+	var errs []error
+	for _, key := range []string{turbineKey("live"), turbineKey(now.Format(time.DateOnly)), turbineKey(now.Format("2006-01")), turbineKey(now.Format("2006"))} {
+		if err := purge.PurgeSurrogateKey(key, purge.PurgeOptions{Soft: true}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// purgeTargets expands a requested key into the surrogate keys to purge and
+// the KV copies to drop. A corrected day changes its month and year too, and
+// a month its year. Keys may be given in full or without the turbine prefix.
+func purgeTargets(key string) (keys, kvKeys []string, err error) {
+	name := strings.TrimPrefix(key, turbineKey()+"-")
+	switch {
+	case key == turbineKey():
+		return []string{key}, nil, nil
+	case name == "live":
+		return []string{turbineKey("live")}, []string{liveKey}, nil
+	}
+	if d, err := time.Parse(time.DateOnly, name); err == nil {
+		keys, kvKeys, _ = purgeTargets(d.Format("2006-01"))
+		keys = append([]string{turbineKey(name)}, keys...)
+		// The last 30 days are stored under the day before today
+		now := time.Now().UTC()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		if d.Before(today) && !d.Before(today.AddDate(0, 0, -30)) {
+			kvKeys = append(kvKeys, today.Add(-time.Second).Format("060102"))
+		}
+		return keys, kvKeys, nil
+	}
+	if m, err := time.Parse("2006-01", name); err == nil {
+		keys, kvKeys, _ = purgeTargets(m.Format("2006"))
+		keys = append([]string{turbineKey(name)}, keys...)
+		kvKeys = append(kvKeys, fmt.Sprintf("monthly-%04d%02d", m.Year(), m.Month()), fmt.Sprintf("current-%04d%02d", m.Year(), m.Month()), fmt.Sprintf("daily-%04d%02d", m.Year(), m.Month()))
+		kvKeys = append(kvKeys, reportKeys(m.Year(), int(m.Month()))...)
+		return keys, kvKeys, nil
+	}
+	if y, err := time.Parse("2006", name); err == nil {
+		return []string{turbineKey(name)}, []string{fmt.Sprintf("yearly-%04d", y.Year()), fmt.Sprintf("%04d", y.Year()), fmt.Sprintf("ytd-%04d", y.Year())}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown key %q", key)
+}
+
+// purgeKeys is POSTed when Vensys data changes, with one or more key
+// parameters naming what changed (live, 2025-04-17, 2025-04, 2025 or
+// turbine-277 for everything). It purges the matching responses and upstream
+// calls from the edge, softly with soft=1, and drops the KV copies built from
+// them.
+func purgeKeys(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	if !bearerAuthorized(r, refreshSecretName) {
+		w.WriteHeader(fsthttp.StatusUnauthorized)
+		fmt.Fprintf(w, "Unauthorized\n")
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(fsthttp.StatusMethodNotAllowed)
+		fmt.Fprintf(w, "Use POST to purge\n")
+		return
+	}
+
+	q := r.URL.Query()
+	var keys, kvKeys []string
+	for _, key := range q["key"] {
+		k, kv, err := purgeTargets(key)
+		if err != nil {
+			w.WriteHeader(fsthttp.StatusBadRequest)
+			fmt.Fprintf(w, "%v\n", err)
+			return
+		}
+		for _, key := range k {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+		kvKeys = append(kvKeys, kv...)
+	}
+	if len(keys) == 0 {
+		w.WriteHeader(fsthttp.StatusBadRequest)
+		fmt.Fprintf(w, "No key given\n")
+		return
+	}
+
+	store, err := kvstore.Open(kvStoreName)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+
+	l := logFor(ctx)
+	soft := q.Get("soft") == "1"
+	var a fastjson.Arena
+	purged := a.NewArray()
+	failed := false
+	for i, key := range keys {
+		o := a.NewObject()
+		o.Set("key", a.NewString(key))
+		if err := purge.PurgeSurrogateKey(key, purge.PurgeOptions{Soft: soft}); err != nil {
+			failed = true
+			l.Error("purging key", err, "surrogateKey", key)
+			o.Set("ok", a.NewFalse())
+			o.Set("error", a.NewString(err.Error()))
+		} else {
+			l.Info("purged key", "surrogateKey", key, "soft", soft)
+			o.Set("ok", a.NewTrue())
+		}
+		purged.SetArrayItem(i, o)
+	}
+	for _, key := range kvKeys {
+		if err := store.Delete(key); err != nil && !errors.Is(err, kvstore.ErrKeyNotFound) {
+			failed = true
+			l.Error("dropping KV copy", err, "kvKey", key)
+		}
+	}
+
+	summary := a.NewObject()
+	summary.Set("soft", a.NewFalse())
+	if soft {
+		summary.Set("soft", a.NewTrue())
+	}
+	summary.Set("purged", purged)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	if failed {
+		w.WriteHeader(fsthttp.StatusInternalServerError)
+	}
+	w.Write(summary.MarshalTo(nil))
+}
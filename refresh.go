@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+	"github.com/fastly/compute-sdk-go/secretstore"
+	"github.com/valyala/fastjson"
+)
+
+const (
+	liveKey           = "live"
+	refreshLockKey    = "refresh-lock"
+	refreshLockTTL    = 5 * 60 // seconds
+	refreshSecretName = "refresh-token"
+	defaultRefreshTTL = 15 // minutes
+)
+
+// refreshStep is one piece of data the refresh job keeps warm.
+type refreshStep struct {
+	Name string
+	Run  func(ctx context.Context, store *kvstore.Store, ttl uint32) error
+}
+
+var refreshSteps = []refreshStep{
+	{"last30", refreshLast30},
+	{"month", refreshCurrentMonth},
+	{"ytd", refreshYearToDate},
+	{"live", refreshLive},
+	{"status", refreshStatus},
+	{"alerts", refreshAlerts},
+	{"purge", refreshPurge},
+}
+
+// refresh is hit by an external scheduler to recompute the data the dashboard
+// needs, so visitors are served from KV instead of waiting on the API.
+func refresh(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	if !bearerAuthorized(r, refreshSecretName) {
+		w.WriteHeader(fsthttp.StatusUnauthorized)
+		fmt.Fprintf(w, "Unauthorized\n")
+		return
+	}
+
+	store, err := kvstore.Open(kvStoreName)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+
+	// The lock expires on its own in case a run dies before releasing it
+	started := time.Now()
+	err = store.InsertWithConfig(refreshLockKey, strings.NewReader(strconv.FormatInt(started.Unix(), 10)), &kvstore.InsertConfig{
+		Mode:   kvstore.InsertModeAdd,
+		TTLSec: refreshLockTTL,
+	})
+	if errors.Is(err, kvstore.ErrPreconditionFailed) {
+		w.WriteHeader(fsthttp.StatusConflict)
+		fmt.Fprintf(w, "Refresh already running\n")
+		return
+	}
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	defer store.Delete(refreshLockKey)
+
+	ttl := refreshTTL()
+
+	var a fastjson.Arena
+	results := a.NewArray()
+	failed := false
+	for i, step := range refreshSteps {
+		stepStart := time.Now()
+		err := step.Run(ctx, store, ttl)
+		o := a.NewObject()
+		o.Set("name", a.NewString(step.Name))
+		o.Set("durationMs", a.NewNumberInt(int(time.Since(stepStart).Milliseconds())))
+		if err != nil {
+			failed = true
+			logFor(ctx).Error("refresh step failed", err, "step", step.Name)
+			o.Set("ok", a.NewFalse())
+			o.Set("error", a.NewString(err.Error()))
+		} else {
+			o.Set("ok", a.NewTrue())
+		}
+		results.SetArrayItem(i, o)
+	}
+	summary := a.NewObject()
+	summary.Set("started", a.NewNumberInt(int(started.Unix())))
+	summary.Set("durationMs", a.NewNumberInt(int(time.Since(started).Milliseconds())))
+	summary.Set("ttl", a.NewNumberInt(int(ttl)))
+	summary.Set("refreshed", results)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	if failed {
+		w.WriteHeader(fsthttp.StatusInternalServerError)
+	}
+	w.Write(summary.MarshalTo(nil))
+}
+
+// refreshTTL is how long the values the refresh job keeps warm stay in KV,
+// in seconds.
+func refreshTTL() uint32 {
+	return uint32(getConfigFloat("refresh-ttl-minutes", defaultRefreshTTL) * 60)
+}
+
+func refreshLast30(ctx context.Context, store *kvstore.Store, ttl uint32) error {
+	_, err := storeLast30(ctx, store, ttl)
+	return err
+}
+
+func refreshCurrentMonth(ctx context.Context, store *kvstore.Store, ttl uint32) error {
+	now := time.Now()
+	yield, err := fetchMonthlyData(ctx, now.Year(), int(now.Month()))
+	if err != nil {
+		return err
+	}
+	data := fmt.Sprintf(`{"data":[{"month":"%04d%02d","energyYield":%f}]}`, now.Year(), now.Month(), yield)
+	return store.InsertWithConfig(fmt.Sprintf("current-%04d%02d", now.Year(), now.Month()), strings.NewReader(data), &kvstore.InsertConfig{TTLSec: ttl})
+}
+
+func refreshYearToDate(ctx context.Context, store *kvstore.Store, ttl uint32) error {
+	now := time.Now()
+	ytd, err := getYearToDateTotalForYear(ctx, now.Year(), int(now.Month()))
+	if err != nil {
+		return err
+	}
+	return store.InsertWithConfig(fmt.Sprintf("ytd-%04d", now.Year()), strings.NewReader(strconv.FormatFloat(ytd, 'f', -1, 64)), &kvstore.InsertConfig{TTLSec: ttl})
+}
+
+func refreshLive(ctx context.Context, store *kvstore.Store, ttl uint32) error {
+	perf, age, err := fetchLatestPerf(ctx)
+	if err != nil {
+		return err
+	}
+	// The fetch time goes in the metadata so getLatestPerf can report the age
+	fetched := time.Now().Add(-time.Duration(age) * time.Second)
+	if err := store.InsertWithConfig(liveKey, strings.NewReader(perf), &kvstore.InsertConfig{
+		TTLSec:   ttl,
+		Metadata: []byte(strconv.FormatInt(fetched.Unix(), 10)),
+	}); err != nil {
+		return err
+	}
+	return store.InsertWithConfig(latencyKey, strings.NewReader(strconv.FormatFloat(upstreamLatency.Seconds(), 'g', -1, 64)), &kvstore.InsertConfig{
+		TTLSec: ttl,
+	})
+}
+
+// bearerAuthorized reports whether the request carries the token stored under
+// secretName as a bearer token. A missing secret denies all requests.
+func bearerAuthorized(r *fsthttp.Request, secretName string) bool {
+	token, err := secretstore.Plaintext(secretStoreName, secretName)
+	if err != nil || len(token) == 0 {
+		return false
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), token) == 1
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+)
+
+// monthlyReport is the data behind the monthly PDF report.
+type monthlyReport struct {
+	Start          time.Time
+	EnergyYield    float64 // MWh
+	CapacityFactor float64 // %
+	YoyChange      float64 // %
+	Days           []dailyRow
+	Events         []turbineEvent
+}
+
+func getMonthlyReport(ctx context.Context, year, month int) (monthlyReport, error) {
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	r := monthlyReport{Start: start}
+
+	var err error
+	r.EnergyYield, err = getMonthlyData(ctx, year, month)
+	if err != nil {
+		return r, err
+	}
+	prevYearYield, err := getMonthlyData(ctx, year-1, month)
+	if err == nil && prevYearYield > 0 {
+		r.YoyChange = ((r.EnergyYield - prevYearYield) / prevYearYield) * 100
+	}
+	theoreticalMaxMWh := (powerNominal / 1000.0) * end.Sub(start).Hours()
+	r.CapacityFactor = (r.EnergyYield / theoreticalMaxMWh) * 100
+
+	err = eachDay(ctx, start, end.AddDate(0, 0, -1), func(d dailyRow) error {
+		r.Days = append(r.Days, d)
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+
+	events, err := getEvents(ctx)
+	if err != nil {
+		return r, err
+	}
+	for _, e := range events {
+		if !e.Time.Before(start) && e.Time.Before(end) {
+			r.Events = append(r.Events, e)
+		}
+	}
+	return r, nil
+}
+
+// renderMonthlyReport lays the report out on a single A4 page, in the units
+// of u and the language of l. The footer links to the dashboard's charts at
+// baseURL for the months around the report.
+func renderMonthlyReport(r monthlyReport, baseURL string, u unitPrefs, l locale) []byte {
+	p := &pdfPage{}
+	const left, right = 50.0, pdfWidth - 50.0
+
+	p.Text(left, 790, 20, true, l.T("Monthly Production Report"))
+	p.Text(left, 770, 12, false, l.T("Turbine %s - %s", TID, l.Date(r.Start, "January 2006")))
+	p.Line(left, 758, right, 758)
+
+	// Headline figures
+	yoy := l.T("n/a")
+	if r.YoyChange != 0 {
+		yoy = l.Num(r.YoyChange, 1) + " %"
+		if r.YoyChange > 0 {
+			yoy = "+" + yoy
+		}
+	}
+	energy := u.EnergyUnit(r.EnergyYield * 1e3)
+	kpis := []struct{ label, value string }{
+		{l.T("Energy"), l.Num(energy.FromKWh(r.EnergyYield*1e3), energy.Decimals) + " " + energy.Name},
+		{l.T("Capacity Factor"), l.Num(r.CapacityFactor, 1) + " %"},
+		{l.T("vs %s", l.Date(r.Start.AddDate(-1, 0, 0), "Jan 2006")), yoy},
+	}
+	for i, k := range kpis {
+		x := left + float64(i)*165
+		p.Text(x, 730, 9, false, k.label)
+		p.Text(x, 710, 16, true, k.value)
+	}
+
+	// Daily energy bar chart
+	const chartTop, chartBottom = 650.0, 450.0
+	dailyUnit := u.EnergyUnit(maxDailyYield(r.Days))
+	p.Text(left, 670, 12, true, l.T("Daily Energy (%s)", dailyUnit.Name))
+	maxYield := dailyUnit.FromKWh(maxDailyYield(r.Days))
+	p.Line(left, chartBottom, right, chartBottom)
+	if maxYield > 0 {
+		p.Line(left, chartTop, right, chartTop)
+		p.Text(left, chartTop+3, 7, false, l.Num(maxYield, dailyUnit.Decimals))
+		slot := (right - left) / float64(r.Start.AddDate(0, 1, -1).Day())
+		for _, d := range r.Days {
+			d = convertDay(d, dailyUnit, u.Wind)
+			x := left + float64(d.Date.Day()-1)*slot
+			h := d.EnergyYield / maxYield * (chartTop - chartBottom)
+			p.Rect(x+1, chartBottom, slot-2, h, 0.145, 0.388, 0.922)
+			if d.Date.Day() == 1 || d.Date.Day()%5 == 0 {
+				p.Text(x+1, chartBottom-12, 7, false, strconv.Itoa(d.Date.Day()))
+			}
+		}
+	} else {
+		p.Text(left, (chartTop+chartBottom)/2, 10, false, l.T("No production data"))
+	}
+
+	// Availability summary
+	y := 400.0
+	p.Text(left, y, 12, true, l.T("Availability"))
+	if len(r.Days) > 0 {
+		avail, lowWind := 0.0, 0.0
+		worst := r.Days[0]
+		for _, d := range r.Days {
+			avail += d.Avail
+			lowWind += d.LowWindTime
+			if d.Avail < worst.Avail {
+				worst = d
+			}
+		}
+		p.Text(left, y-18, 10, false, l.T("Mean availability: %s %%", l.Num(avail/float64(len(r.Days)), 1)))
+		p.Text(left, y-32, 10, false, l.T("Lowest day: %s at %s %%", l.Date(worst.Date, "2 Jan"), l.Num(worst.Avail, 1)))
+		p.Text(left, y-46, 10, false, l.T("Low wind time: %s hours", l.Num(lowWind/3600, 1)))
+	}
+
+	// Notable events
+	y = 320
+	p.Text(left, y, 12, true, l.T("Notable Events"))
+	if len(r.Events) == 0 {
+		p.Text(left, y-18, 10, false, l.T("No status changes recorded."))
+	}
+	for i, e := range r.Events {
+		if i == 15 {
+			p.Text(left, y-18-float64(i)*14, 10, false, l.T("... and %d more", len(r.Events)-i))
+			break
+		}
+		line := fmt.Sprintf("%s  %s", l.Date(e.Time, "2 Jan 15:04"), l.T(e.State))
+		if e.ErrorCode != 0 {
+			line += fmt.Sprintf(" (%s %d)", l.T("code"), e.ErrorCode)
+		}
+		if e.Text != "" {
+			line += " - " + e.Text
+		}
+		p.Text(left, y-18-float64(i)*14, 10, false, line)
+	}
+
+	p.Line(left, 50, right, 50)
+	p.Text(left, 38, 8, false, l.T("Generated %s", l.Date(time.Now().UTC(), "2 Jan 2006 15:04 MST")))
+	prefs := url.Values{"energy": {u.EnergyPref()}, "lang": {l.Lang}}.Encode()
+	p.Text(left, 26, 8, true, l.T("Charts:"))
+	for i, c := range []struct{ name, label string }{
+		{"daily", l.T("Last 30 Days")},
+		{"monthly", l.T("Last 12 Months")},
+		{"yearly", l.T("Every Year")},
+	} {
+		x := left + 50 + float64(i)*80
+		p.Text(x, 26, 8, false, c.label)
+		p.Link(x, 23, 70, 11, baseURL+"/charts/"+c.name+".svg?"+prefs)
+	}
+	return pdfDocument([]*pdfPage{p})
+}
+
+// reportKey is the KV key a completed month's report is kept under. Each
+// energy unit and language is a separate PDF, the default ones keep the
+// plain key.
+func reportKey(year, month int, energyPref, lang string) string {
+	key := fmt.Sprintf("report-%04d%02d", year, month)
+	if energyPref != "auto" || lang != defaultLang {
+		key += "-" + energyPref + "-" + lang
+	}
+	return key
+}
+
+// reportKeys returns the KV keys of every variant of a month's report.
+func reportKeys(year, month int) []string {
+	var keys []string
+	for _, energyPref := range []string{"auto", "kwh", "mwh", "gwh"} {
+		for lang := range locales {
+			keys = append(keys, reportKey(year, month, energyPref, lang))
+		}
+	}
+	return keys
+}
+
+// reportMonthly serves the PDF report for a month. Completed months are kept
+// in KV since they no longer change.
+func reportMonthly(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+	q := r.URL.Query()
+	year, err := strconv.Atoi(q.Get("year"))
+	if err != nil {
+		w.WriteHeader(fsthttp.StatusBadRequest)
+		fmt.Fprintf(w, "Bad year")
+		return
+	}
+	month, err := strconv.Atoi(q.Get("month"))
+	if err != nil || month < 1 || month > 12 {
+		w.WriteHeader(fsthttp.StatusBadRequest)
+		fmt.Fprintf(w, "Bad month")
+		return
+	}
+	now := time.Now()
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	currentMonthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if start.After(currentMonthStart) {
+		w.WriteHeader(fsthttp.StatusBadRequest)
+		fmt.Fprintf(w, "Month is in the future")
+		return
+	}
+	isCompletedPastMonth := start.Before(currentMonthStart)
+
+	store, err := kvstore.Open(kvStoreName)
+	if err != nil {
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Internal Server Error", err)
+		return
+	}
+	u, l := getUnitPrefs(r), negotiateLocale(r)
+	filename := fmt.Sprintf("report_%04d%02d.pdf", year, month)
+	keyStr := reportKey(year, month, u.EnergyPref(), l.Lang)
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "inline; filename="+filename)
+	w.Header().Add("Vary", "Accept-Language")
+	setSurrogateKeys(w, monthKeys(start, start))
+	if isCompletedPastMonth {
+		if entry, err := kvLookup(ctx, store, keyStr); err == nil {
+			w.Header().Set("Cache-Control", "public, max-age=86400")
+			fmt.Fprint(w, entry.String())
+			return
+		}
+	}
+
+	report, err := getMonthlyReport(ctx, year, month)
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		w.Header().Set("Content-Type", "text/plain")
+		httpError(ctx, w, fsthttp.StatusInternalServerError, "Error building report", err)
+		return
+	}
+	pdf := renderMonthlyReport(report, "https://"+r.Host, u, l)
+	// Only a month with data is kept, an empty one may just not have been
+	// published yet
+	if isCompletedPastMonth && report.EnergyYield > 0 {
+		store.Insert(keyStr, bytes.NewReader(pdf))
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=600")
+	}
+	w.Write(pdf)
+}
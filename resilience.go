@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fastjson"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/fastly/compute-sdk-go/kvstore"
+)
+
+const (
+	defaultUpstreamTimeout = 10 // seconds
+	maxUpstreamAttempts    = 3
+	retryBaseDelay         = 200 * time.Millisecond
+	maxRetryDelay          = 2 * time.Second
+
+	// The breaker opens after this many failed calls within the window and
+	// then stays open for the cool-down.
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 60     // seconds
+	breakerWindow          = 5 * 60 // seconds
+	breakerKeyPrefix       = "breaker-"
+)
+
+// breakerBackends are the backends guarded by the circuit breaker. Webhooks
+// and the mail API are left alone, one bad hook shouldn't block the others.
+var breakerBackends = map[string]bool{
+	backendName:       true,
+	backendNameCached: true,
+}
+
+// errCircuitOpen is returned instead of calling a backend that has been
+// failing, so callers fall back to their cached data straight away.
+var errCircuitOpen = errors.New("circuit open")
+
+// upstreamLatency is how long the last call to the turbine API took in this
+// request, for the refresh job to store and /metrics to report.
+var upstreamLatency time.Duration
+
+// breakerState is kept in KV per backend.
+type breakerState struct {
+	Failures  int
+	OpenUntil time.Time
+}
+
+func getBreaker(ctx context.Context, store *kvstore.Store, backend string) breakerState {
+	var s breakerState
+	entry, err := kvLookup(ctx, store, breakerKeyPrefix+backend)
+	if err != nil {
+		return s
+	}
+	v, err := fastjson.Parse(entry.String())
+	if err != nil {
+		return s
+	}
+	s.Failures = v.GetInt("failures")
+	if ts := v.GetInt64("openUntil"); ts > 0 {
+		s.OpenUntil = time.Unix(ts, 0)
+	}
+	return s
+}
+
+func putBreaker(store *kvstore.Store, backend string, s breakerState) error {
+	var a fastjson.Arena
+	o := a.NewObject()
+	o.Set("failures", a.NewNumberInt(s.Failures))
+	o.Set("openUntil", a.NewNumberInt(int(s.OpenUntil.Unix())))
+	ttl := breakerWindow
+	if until := int(time.Until(s.OpenUntil).Seconds()); until > ttl {
+		ttl = until
+	}
+	return store.InsertWithConfig(breakerKeyPrefix+backend, bytes.NewReader(o.MarshalTo(nil)), &kvstore.InsertConfig{
+		TTLSec: uint32(ttl),
+	})
+}
+
+// recordUpstream updates the breaker after a call, including its retries.
+// Failures count up until the threshold opens the breaker; a success closes
+// it again.
+func recordUpstream(ctx context.Context, store *kvstore.Store, backend string, s breakerState, ok bool) {
+	l := logFor(ctx)
+	if ok {
+		if s.Failures > 0 {
+			if err := store.Delete(breakerKeyPrefix + backend); err != nil {
+				l.Warn("closing circuit", err, "upstream", backend)
+			} else {
+				l.Info("circuit closed", "upstream", backend)
+			}
+		}
+		return
+	}
+	s.Failures++
+	if s.Failures >= int(getConfigFloat("breaker-failures", defaultBreakerFailures)) {
+		cooldown := time.Duration(getConfigFloat("breaker-cooldown-seconds", defaultBreakerCooldown)) * time.Second
+		// Failures stay at the threshold, so after the cool-down a single
+		// failed trial call opens the breaker again
+		s.OpenUntil = time.Now().Add(cooldown)
+		l.Warn("circuit opened", nil, "upstream", backend, "openUntil", s.OpenUntil.UTC().Format(time.RFC3339))
+	}
+	if err := putBreaker(store, backend, s); err != nil {
+		l.Warn("recording upstream failure", err, "upstream", backend)
+	}
+}
+
+// retryable reports whether an attempt is worth repeating: the call itself
+// failed, the backend is overloaded or it had a server error.
+func retryable(resp *fsthttp.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == fsthttp.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryDelay backs off exponentially, or as long as Retry-After asks, up to
+// maxRetryDelay.
+func retryDelay(resp *fsthttp.Response, attempt int) time.Duration {
+	d := retryBaseDelay << (attempt - 1)
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			d = time.Duration(secs) * time.Second
+		}
+	}
+	return min(d, maxRetryDelay)
+}
+
+// sendWithRetry sends req with a deadline per attempt, retrying idempotent
+// requests, and keeps the circuit breaker for guarded backends.
+func sendWithRetry(ctx context.Context, req *fsthttp.Request, backend string) (*fsthttp.Response, error) {
+	l := logFor(ctx)
+
+	var store *kvstore.Store
+	var breaker breakerState
+	if breakerBackends[backend] {
+		var err error
+		if store, err = kvstore.Open(kvStoreName); err == nil {
+			breaker = getBreaker(ctx, store, backend)
+			if time.Now().Before(breaker.OpenUntil) {
+				l.Warn("upstream call skipped", errCircuitOpen, "upstream", backend, "path", req.URL.Path, "openUntil", breaker.OpenUntil.UTC().Format(time.RFC3339))
+				return nil, fmt.Errorf("%s: %w", backend, errCircuitOpen)
+			}
+		}
+	}
+
+	attempts := 1
+	if req.Method == "GET" || req.Method == "HEAD" {
+		attempts = maxUpstreamAttempts
+	}
+	timeout := time.Duration(getConfigFloat("upstream-timeout-seconds", defaultUpstreamTimeout) * float64(time.Second))
+
+	var resp *fsthttp.Response
+	var err error
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 {
+			r = req.Clone()
+		}
+		resp, err = sendAttempt(ctx, r, backend, timeout, attempt)
+		if attempt >= attempts || !retryable(resp, err) || ctx.Err() != nil {
+			break
+		}
+		delay := retryDelay(resp, attempt)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	if store != nil {
+		recordUpstream(ctx, store, backend, breaker, !retryable(resp, err))
+	}
+	return resp, err
+}
+
+// sendAttempt makes one call with its own deadline and logs it.
+func sendAttempt(ctx context.Context, req *fsthttp.Request, backend string, timeout time.Duration, attempt int) (*fsthttp.Response, error) {
+	l := logFor(ctx)
+	actx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := req.Send(actx, backend)
+	took := time.Since(start)
+	if breakerBackends[backend] {
+		upstreamLatency = took
+	}
+	kv := []any{"upstream", backend, "path", req.URL.Path, "attempt", attempt, "durationMs", millis(took)}
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		err = fmt.Errorf("%s: timed out after %s", backend, timeout)
+	}
+	if err != nil {
+		l.Error("upstream call", err, kv...)
+		return resp, err
+	}
+	age, _ := resp.Age()
+	l.Info("upstream call", append(kv, "status", resp.StatusCode, "age", age)...)
+	return resp, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"strings"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+	"github.com/valyala/fastjson"
+)
+
+// energyUnit is a unit energy values can be shown in.
+type energyUnit struct {
+	Name     string
+	KWh      float64 // size in kWh
+	Decimals int     // for display
+}
+
+var energyUnits = []energyUnit{
+	{"kWh", 1, 0},
+	{"MWh", 1e3, 1},
+	{"GWh", 1e6, 2},
+}
+
+// windUnit is a unit wind speeds can be shown in.
+type windUnit struct {
+	Name   string
+	Factor float64 // per m/s
+}
+
+var windUnits = map[string]windUnit{
+	"ms":  {"m/s", 1},
+	"kmh": {"km/h", 3.6},
+	"kn":  {"kn", 3600.0 / 1852},
+}
+
+// unitPrefs are the units a response uses. A nil Energy means each set of
+// values is scaled to suit its size.
+type unitPrefs struct {
+	Energy *energyUnit
+	Wind   windUnit
+}
+
+// getUnitPrefs reads the energy (auto, kwh, mwh or gwh) and wind (ms, kmh or
+// kn) query parameters, falling back to energy-unit and wind-unit in the
+// config store.
+func getUnitPrefs(r *fsthttp.Request) unitPrefs {
+	q := r.URL.Query()
+	u := unitPrefs{Wind: windUnits["ms"]}
+
+	energy := q.Get("energy")
+	if energy == "" {
+		energy = getConfig("energy-unit", "auto")
+	}
+	for i, e := range energyUnits {
+		if strings.EqualFold(e.Name, energy) {
+			u.Energy = &energyUnits[i]
+		}
+	}
+
+	wind := q.Get("wind")
+	if wind == "" {
+		wind = getConfig("wind-unit", "ms")
+	}
+	if w, ok := windUnits[strings.ToLower(wind)]; ok {
+		u.Wind = w
+	}
+	return u
+}
+
+// EnergyPref is the energy query parameter value for the preference.
+func (u unitPrefs) EnergyPref() string {
+	if u.Energy == nil {
+		return "auto"
+	}
+	return strings.ToLower(u.Energy.Name)
+}
+
+// EnergyUnit returns the unit for values up to maxKWh. Automatic scaling
+// picks the largest unit the value is still at least 1 in.
+func (u unitPrefs) EnergyUnit(maxKWh float64) energyUnit {
+	if u.Energy != nil {
+		return *u.Energy
+	}
+	unit := energyUnits[0]
+	for _, e := range energyUnits[1:] {
+		if math.Abs(maxKWh) >= e.KWh {
+			unit = e
+		}
+	}
+	return unit
+}
+
+// FromKWh converts kWh into the unit.
+func (e energyUnit) FromKWh(kwh float64) float64 {
+	return kwh / e.KWh
+}
+
+// WindSpeed converts m/s into the preferred unit.
+func (u unitPrefs) WindSpeed(ms float64) float64 {
+	return ms * u.Wind.Factor
+}
+
+// setUnitsHeader describes the units of a response in the X-Units header, for
+// formats like CSV that have nowhere else to put it.
+func setUnitsHeader(w fsthttp.ResponseWriter, energy energyUnit, wind windUnit) {
+	w.Header().Set("X-Units", "energy="+energy.Name+", wind="+wind.Name)
+}
+
+// unitsValue is the units object added to JSON responses.
+func unitsValue(a *fastjson.Arena, energy energyUnit, wind windUnit) *fastjson.Value {
+	o := a.NewObject()
+	o.Set("energy", a.NewString(energy.Name))
+	o.Set("wind", a.NewString(wind.Name))
+	return o
+}
+
+// maxArray returns the largest number in v[key].
+func maxArray(v *fastjson.Value, key string) float64 {
+	m := 0.0
+	for _, n := range v.GetArray(key) {
+		m = max(m, n.GetFloat64())
+	}
+	return m
+}
+
+// scaleArray multiplies every number in v[key] by factor.
+func scaleArray(a *fastjson.Arena, v *fastjson.Value, key string, factor float64) {
+	arr := v.Get(key)
+	for i, n := range v.GetArray(key) {
+		arr.SetArrayItem(i, a.NewNumberFloat64(n.GetFloat64()*factor))
+	}
+}
+
+// prefQuery returns the explicitly chosen language and unit parameters of r,
+// so links can carry them over.
+func prefQuery(r *fsthttp.Request) url.Values {
+	q := url.Values{}
+	for _, key := range []string{"lang", "energy", "wind"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			q.Set(key, v)
+		}
+	}
+	return q
+}
+
+// prefLink links to the dashboard with one preference changed.
+func prefLink(r *fsthttp.Request) func(key, value string) string {
+	return func(key, value string) string {
+		q := prefQuery(r)
+		q.Set(key, value)
+		return "/?" + q.Encode()
+	}
+}
+
+// convertAggregate converts the energyYield of monthly or yearly aggregates,
+// stored in units of toKWh, to the preferred unit and records the units used.
+func convertAggregate(w fsthttp.ResponseWriter, v *fastjson.Value, u unitPrefs, toKWh float64) energyUnit {
+	var a fastjson.Arena
+	energy := u.EnergyUnit(maxArray(v, "energyYield") * toKWh)
+	scaleArray(&a, v, "energyYield", toKWh/energy.KWh)
+	v.Set("units", unitsValue(&a, energy, u.Wind))
+	setUnitsHeader(w, energy, u.Wind)
+	return energy
+}
+
+// convertDay converts a day's energy and wind speeds to the given units.
+func convertDay(d dailyRow, energy energyUnit, wind windUnit) dailyRow {
+	d.EnergyYield = energy.FromKWh(d.EnergyYield)
+	d.WindAvg *= wind.Factor
+	d.WindMax *= wind.Factor
+	return d
+}
+
+// maxDailyYield returns the best day's energy in kWh.
+func maxDailyYield(days []dailyRow) float64 {
+	m := 0.0
+	for _, d := range days {
+		m = max(m, d.EnergyYield)
+	}
+	return m
+}
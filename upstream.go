@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+)
+
+// Cache lifetimes of Vensys responses at the edge, in seconds. Data that is
+// still coming in is kept briefly, completed days and months for long since
+// they only change if Vensys corrects them, and then they are purged by key.
+const (
+	liveTTL           = 10 * 60
+	completedDayTTL   = 24 * 60 * 60
+	completedMonthTTL = 30 * 24 * 60 * 60
+)
+
+// upstreamRoute says where a Vensys API call goes and how it is cached.
+type upstreamRoute struct {
+	Backend      string
+	Host         string
+	TTL          uint32
+	SurrogateKey string // space separated
+}
+
+// turbineKey is the surrogate key for the turbine's data, narrowed down by
+// parts, e.g. turbineKey("2025-04") for a month.
+func turbineKey(parts ...string) string {
+	return strings.Join(append([]string{"turbine", TID}, parts...), "-")
+}
+
+// monthKeys returns the turbine key and the key of every month from..to
+// touches, so a completed month can be purged without the rest.
+func monthKeys(from, to time.Time) []string {
+	keys := []string{turbineKey()}
+	for m := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(to); m = m.AddDate(0, 1, 0) {
+		keys = append(keys, turbineKey(m.Format("2006-01")))
+	}
+	return keys
+}
+
+// liveRoute goes straight to the origin, the shield would only add a hop and
+// more staleness to data that changes every few minutes.
+func liveRoute() upstreamRoute {
+	return upstreamRoute{
+		Backend:      backendName,
+		Host:         backendURL,
+		TTL:          liveTTL,
+		SurrogateKey: turbineKey() + " " + turbineKey("live"),
+	}
+}
+
+// rangeRoute sends ranges that ended before today through the vensys-cached
+// shield with a long TTL: a day once it is over, a month once all of it is.
+// Ranges that include today go to the origin like live data.
+func rangeRoute(from, to time.Time) upstreamRoute {
+	r := upstreamRoute{
+		Backend:      backendName,
+		Host:         backendURL,
+		TTL:          liveTTL,
+		SurrogateKey: strings.Join(monthKeys(from, to), " "),
+	}
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if !to.Before(today) {
+		return r
+	}
+	r.Backend, r.Host = backendNameCached, backendURLCached
+	r.TTL = uint32(getConfigFloat("completed-day-ttl-seconds", completedDayTTL))
+	if to.Before(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)) {
+		r.TTL = uint32(getConfigFloat("completed-month-ttl-seconds", completedMonthTTL))
+	}
+	return r
+}
+
+// vensysGet calls the Vensys customer API endpoint with query along route. If
+// the shield is down the call is repeated against the origin.
+func vensysGet(ctx context.Context, endpoint string, query url.Values, route upstreamRoute) (*fsthttp.Response, error) {
+	u := url.URL{
+		Scheme:   "https",
+		Host:     route.Host,
+		Path:     "/api/v1.0/Customer/" + endpoint,
+		RawQuery: query.Encode(),
+	}
+	req, err := fsthttp.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("ApiKey", getKey())
+	req.Header.Set("TID", TID)
+	req.CacheOptions = fsthttp.CacheOptions{TTL: route.TTL, SurrogateKey: route.SurrogateKey}
+
+	resp, err := send(ctx, req, route.Backend)
+	if route.Backend == backendNameCached && retryable(resp, err) && ctx.Err() == nil {
+		logFor(ctx).Warn("shield unavailable, using origin", err, "path", u.Path)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		route.Backend, route.Host = backendName, backendURL
+		return vensysGet(ctx, endpoint, query, route)
+	}
+	return resp, err
+}
+
+// rangeQuery is the From/To query of a range request.
+func rangeQuery(from, to time.Time) url.Values {
+	q := url.Values{}
+	q.Add("From", strconv.FormatInt(from.Unix(), 10))
+	q.Add("To", strconv.FormatInt(to.Unix(), 10))
+	return q
+}
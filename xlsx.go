@@ -0,0 +1,230 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// Cell styles defined in xlsxStyles.
+const (
+	xlsxStyleDefault = 0
+	xlsxStyleHeader  = 1
+	xlsxStyleNumber  = 2
+)
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+
+func xmlEscape(s string) string {
+	return xmlEscaper.Replace(s)
+}
+
+// xlsxWriter streams a minimal Office Open XML workbook. Sheets are written
+// one after the other, each with a bold, frozen header row. Cells are either
+// strings or float64s. It sticks to archive/zip and hand written XML so it
+// builds under TinyGo.
+type xlsxWriter struct {
+	zw     *zip.Writer
+	sheets []string
+	sheet  io.Writer
+	row    int
+}
+
+func newXLSXWriter(w io.Writer) *xlsxWriter {
+	return &xlsxWriter{zw: zip.NewWriter(w)}
+}
+
+// StartSheet ends the current sheet, if any, and starts a new one.
+func (x *xlsxWriter) StartSheet(name string, header ...string) error {
+	if err := x.endSheet(); err != nil {
+		return err
+	}
+	x.sheets = append(x.sheets, name)
+	f, err := x.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(x.sheets)))
+	if err != nil {
+		return err
+	}
+	x.sheet = f
+	x.row = 0
+	_, err = io.WriteString(f, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`+
+		`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`+
+		`<sheetData>`)
+	if err != nil {
+		return err
+	}
+	cells := make([]any, len(header))
+	for i, h := range header {
+		cells[i] = h
+	}
+	return x.writeRow(xlsxStyleHeader, cells)
+}
+
+// Row appends a row of cells to the current sheet.
+func (x *xlsxWriter) Row(cells ...any) error {
+	return x.writeRow(xlsxStyleDefault, cells)
+}
+
+func (x *xlsxWriter) writeRow(style int, cells []any) error {
+	x.row++
+	var b strings.Builder
+	fmt.Fprintf(&b, `<row r="%d">`, x.row)
+	for i, c := range cells {
+		ref := xlsxColumn(i) + strconv.Itoa(x.row)
+		switch v := c.(type) {
+		case float64:
+			s := style
+			if s == xlsxStyleDefault {
+				s = xlsxStyleNumber
+			}
+			fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, s, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			fmt.Fprintf(&b, `<c r="%s" s="%d" t="inlineStr"><is><t>%s</t></is></c>`, ref, style, xmlEscape(fmt.Sprint(v)))
+		}
+	}
+	b.WriteString(`</row>`)
+	_, err := io.WriteString(x.sheet, b.String())
+	return err
+}
+
+func (x *xlsxWriter) endSheet() error {
+	if x.sheet == nil {
+		return nil
+	}
+	_, err := io.WriteString(x.sheet, `</sheetData></worksheet>`)
+	x.sheet = nil
+	return err
+}
+
+// Close ends the last sheet and writes the workbook parts.
+func (x *xlsxWriter) Close() error {
+	if err := x.endSheet(); err != nil {
+		return err
+	}
+
+	var types, sheets, rels strings.Builder
+	types.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	rels.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i, name := range x.sheets {
+		n := i + 1
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(name), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+	types.WriteString(`</Types>`)
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(x.sheets)+1)
+	rels.WriteString(`</Relationships>`)
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", types.String()},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets>` + sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, p := range parts {
+		f, err := x.zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, p.body); err != nil {
+			return err
+		}
+	}
+	return x.zw.Close()
+}
+
+// xlsxColumn converts a zero based column index to its letter reference.
+func xlsxColumn(i int) string {
+	s := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		s = string(rune('A'+(i-1)%26)) + s
+	}
+	return s
+}
+
+// Default, bold header and two decimal number styles.
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	`<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="3">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`<xf numFmtId="2" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
+
+// writeAggregateXLSX writes the monthly or yearly aggregates as a workbook
+// with a summary sheet followed by the data.
+func writeAggregateXLSX(w io.Writer, v *fastjson.Value, labelsKey, labelHeader, energyHeader, period string) error {
+	labels := v.GetArray(labelsKey)
+	yields := v.GetArray("energyYield")
+	cfs := v.GetArray("capacityFactor")
+	yoys := v.GetArray("yoyChange")
+
+	total, cfSum, best := 0.0, 0.0, 0
+	for i := range labels {
+		total += yields[i].GetFloat64()
+		cfSum += cfs[i].GetFloat64()
+		if yields[i].GetFloat64() > yields[best].GetFloat64() {
+			best = i
+		}
+	}
+	meanCF := 0.0
+	bestLabel := ""
+	if len(labels) > 0 {
+		meanCF = cfSum / float64(len(labels))
+		bestLabel = string(labels[best].GetStringBytes())
+	}
+
+	x := newXLSXWriter(w)
+	if err := x.StartSheet("Summary", "Item", "Value"); err != nil {
+		return err
+	}
+	summary := [][]any{
+		{"Turbine", TID},
+		{"Period", period},
+		{"Total " + energyHeader, total},
+		{"Mean Capacity Factor (%)", meanCF},
+		{"Best " + labelHeader, bestLabel},
+		{"Generated", time.Now().UTC().Format(time.RFC3339)},
+	}
+	for _, row := range summary {
+		if err := x.Row(row...); err != nil {
+			return err
+		}
+	}
+
+	if err := x.StartSheet("Data", labelHeader, energyHeader, "Capacity Factor (%)", "YoY Change (%)"); err != nil {
+		return err
+	}
+	for i, l := range labels {
+		err := x.Row(string(l.GetStringBytes()), yields[i].GetFloat64(), cfs[i].GetFloat64(), yoys[i].GetFloat64())
+		if err != nil {
+			return err
+		}
+	}
+	return x.Close()
+}